@@ -5,6 +5,7 @@ import (
 
 	"github.com/brentp/bix"
 	"github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
 )
 
 func benchmarkStreams(nStreams int, b *testing.B) {
@@ -40,3 +41,47 @@ func benchmarkStreams(nStreams int, b *testing.B) {
 
 func Benchmark2Streams(b *testing.B) { benchmarkStreams(2, b) }
 func Benchmark3Streams(b *testing.B) { benchmarkStreams(3, b) }
+
+// pileup returns n reads of length 100 at depth about depth.
+func pileup(n, depth int) []interfaces.Relatable {
+	rels := make([]interfaces.Relatable, n)
+	for i := range rels {
+		s := uint32(i * 100 / depth)
+		rels[i] = iv("chr1", s, s+100)
+	}
+	return rels
+}
+
+func benchmarkPileup(depth int, b *testing.B) {
+	db := pileup(200000, depth)
+	for n := 0; n < b.N; n++ {
+		query := spaced("chr1", 200, 1000, 50)
+		iter := IRelate(CheckRelatedByOverlap, 0, Less, sliceToIterator(query), sliceToIterator(db))
+		for {
+			if _, err := iter.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkPileup100(b *testing.B)  { benchmarkPileup(100, b) }
+func BenchmarkPileup1000(b *testing.B) { benchmarkPileup(1000, b) }
+
+// BenchmarkBam relates sparse intervals to the reads in data/ex.bam.
+func BenchmarkBam(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		bam, err := parsers.NewBamIterator("data/ex.bam")
+		if err != nil {
+			b.Fatal(err)
+		}
+		query := spaced("chr1", 2000, 5000, 500)
+		iter := IRelate(CheckRelatedByOverlap, 0, Less, sliceToIterator(query), bam)
+		for {
+			if _, err := iter.Next(); err != nil {
+				break
+			}
+		}
+		iter.Close()
+	}
+}
@@ -0,0 +1,190 @@
+package irelate
+
+import (
+	"context"
+	"sync"
+
+	"github.com/brentp/irelate/interfaces"
+)
+
+// batch is a sub-chunk of query intervals with their related intervals. cost
+// is the total Size of the intervals counted against MaxInFlight.
+type batch struct {
+	rels []interfaces.Relatable
+	cost int64
+}
+
+// recordSize is the default PIRelateOptions.Size: the number of records.
+func recordSize(r interfaces.Relatable) int64 {
+	return int64(1 + len(r.Related()))
+}
+
+// budget caps the cost of the query intervals (with their related intervals)
+// that have been swept but not yet sent. A nil *budget has no cap.
+type budget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int64
+	used int64
+	// head is the first chunk that has not been sent. Its sweep never waits
+	// so that the chunks after it, which hold the rest of the budget, can not
+	// block it.
+	head int
+	// sent holds the chunks after head that have been sent with Unordered.
+	sent    map[int]bool
+	stopped bool
+}
+
+// newBudget returns a budget of limit or nil if limit <= 0. Waiting stops when
+// ctx is done.
+func newBudget(ctx context.Context, limit int64) *budget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &budget{max: limit, sent: make(map[int]bool)}
+	b.cond = sync.NewCond(&b.mu)
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.stopped = true
+		b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	return b
+}
+
+// waits reports whether acquire(chunk, n) must wait. b.mu must be held.
+func (b *budget) waits(chunk int, n int64) bool {
+	return b.used > 0 && b.used+n > b.max && chunk != b.head && !b.stopped
+}
+
+// full reports whether acquire(chunk, n) would wait now. A sweep sends the
+// intervals it holds before it waits so that they can be released.
+func (b *budget) full(chunk int, n int64) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waits(chunk, n)
+}
+
+// acquire waits until n can be added to the cost in flight for the given
+// chunk. It returns false if it was stopped.
+func (b *budget) acquire(chunk int, n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.waits(chunk, n) {
+		b.cond.Wait()
+	}
+	if b.stopped {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release removes n from the cost in flight.
+func (b *budget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// inUse returns the cost in flight.
+func (b *budget) inUse() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// done is called when a chunk has been sent.
+func (b *budget) done(chunk int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.sent[chunk] = true
+	for b.sent[b.head] {
+		delete(b.sent, b.head)
+		b.head++
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// limits holds the caps on the work in flight in PIRelate.
+type limits struct {
+	// chunks holds a token for each chunk that has been read but not sent. It
+	// is nil if there is no cap.
+	chunks chan struct{}
+	// sweeping holds a token for each chunk that is swept when the chunks are
+	// sent unordered. It is nil otherwise; the queue of chunks waiting to be
+	// sent in order is the cap.
+	sweeping chan struct{}
+	cost     *budget
+	size     func(interfaces.Relatable) int64
+}
+
+// newLimits returns the limits for opts, which must have its defaults set.
+func newLimits(ctx context.Context, opts *PIRelateOptions) *limits {
+	l := &limits{cost: newBudget(ctx, opts.MaxInFlight), size: opts.Size}
+	if opts.MaxChunks > 0 {
+		l.chunks = make(chan struct{}, opts.MaxChunks)
+	}
+	if opts.Unordered {
+		l.sweeping = make(chan struct{}, opts.ChunkQueue)
+	}
+	if l.size == nil {
+		l.size = recordSize
+	}
+	return l
+}
+
+// startChunk waits until another chunk can be read. It returns false if done
+// is closed first.
+func (l *limits) startChunk(done <-chan struct{}) bool {
+	if l.chunks == nil {
+		return true
+	}
+	select {
+	case l.chunks <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// startSweep waits until another chunk can be swept. It returns false if done
+// is closed first.
+func (l *limits) startSweep(done <-chan struct{}) bool {
+	if l.sweeping == nil {
+		return true
+	}
+	select {
+	case l.sweeping <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// endChunk is called once a chunk has been sent.
+func (l *limits) endChunk(chunk int) {
+	if l.chunks != nil {
+		<-l.chunks
+	}
+	if l.sweeping != nil {
+		<-l.sweeping
+	}
+	l.cost.done(chunk)
+}
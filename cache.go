@@ -0,0 +1,202 @@
+package irelate
+
+import (
+	"container/heap"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// entry is an interval in the sweep cache.
+type entry struct {
+	Relatable
+	// seq is the position of the interval in the merged stream.
+	seq int
+	// expired is set when the interval ends (with reach) before the current
+	// position. It then moves from live to lingering.
+	expired bool
+	// dead is set when the interval leaves the cache. It is removed from the
+	// structures that still hold it lazily.
+	dead  bool
+	query bool
+	// span is set for intervals in the spanning side structure.
+	span bool
+}
+
+// entryQueue is a min-heap of entries ordered by End().
+type entryQueue []*entry
+
+func (q entryQueue) Len() int            { return len(q) }
+func (q entryQueue) Less(i, j int) bool  { return q[i].End() < q[j].End() }
+func (q entryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *entryQueue) Push(i interface{}) { *q = append(*q, i.(*entry)) }
+func (q *entryQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+// entryList holds entries in the order they were added to the cache;
+// e[head] is the first that is still in the cache.
+type entryList struct {
+	e    []*entry
+	head int
+}
+
+// front returns the first entry that is not dead or nil if there is none.
+func (l *entryList) front() *entry {
+	for l.head < len(l.e) && l.e[l.head].dead {
+		l.e[l.head] = nil
+		l.head++
+	}
+	if l.head > 1024 && l.head > len(l.e)/2 {
+		n := copy(l.e, l.e[l.head:])
+		for k := n; k < len(l.e); k++ {
+			l.e[k] = nil
+		}
+		l.e, l.head = l.e[:n], 0
+	}
+	if l.head < len(l.e) {
+		return l.e[l.head]
+	}
+	return nil
+}
+
+// bySeq sorts entries in the order they were added to the cache.
+type bySeq []*entry
+
+func (b bySeq) Len() int           { return len(b) }
+func (b bySeq) Less(i, j int) bool { return b[i].seq < b[j].seq }
+func (b bySeq) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// spanLength is the length above which an interval is kept in the spanning
+// side structure of the sweep cache (e.g. a large deletion, a whole gene or a
+// centromere mask).
+const spanLength = 1 << 16
+
+// sweepCache holds the intervals that may still be related to an incoming
+// interval. Intervals that end after the current position are live and are
+// kept by source so that only the sources that can be related to an incoming
+// interval are tested against it. ends orders them by End() so they expire
+// without a scan of the cache.
+type sweepCache struct {
+	live [][]*entry
+	// gone is the number of expired or dead entries in each list of live.
+	gone []int
+	ends entryQueue
+	// lingering holds the intervals that have expired but were related to the
+	// last incoming interval. They are tested against every incoming interval
+	// and leave the cache once they are not related.
+	lingering []*entry
+	// spanning is a min-heap by End() of the intervals longer than
+	// spanLength that have not expired. They are not in live, ends or order
+	// so they do not hold the other intervals in memory for as long as they
+	// are in the cache.
+	spanning entryQueue
+	// order holds the query intervals that are not spanning and spanOrder
+	// the ones that are.
+	order     entryList
+	spanOrder entryList
+	seq       int
+}
+
+// add puts r in the cache. query is true if r is a query interval.
+func (c *sweepCache) add(r Relatable, query bool) {
+	c.seq++
+	e := &entry{Relatable: r, seq: c.seq, query: query}
+	src := int(r.Source())
+	for len(c.live) <= src {
+		c.live = append(c.live, nil)
+		c.gone = append(c.gone, 0)
+	}
+	if r.End() > r.Start() && r.End()-r.Start() > spanLength {
+		e.span = true
+		heap.Push(&c.spanning, e)
+		if query {
+			c.spanOrder.e = append(c.spanOrder.e, e)
+		}
+		return
+	}
+	if c.gone[src] > 64 && c.gone[src] > len(c.live[src])/2 {
+		c.live[src], c.gone[src] = compact(c.live[src]), 0
+	}
+	c.live[src] = append(c.live[src], e)
+	heap.Push(&c.ends, e)
+	if query {
+		c.order.e = append(c.order.e, e)
+	}
+}
+
+// compact removes the expired and dead entries from l.
+func compact(l []*entry) []*entry {
+	j := 0
+	for _, e := range l {
+		if !e.expired && !e.dead {
+			l[j] = e
+			j++
+		}
+	}
+	for k := j; k < len(l); k++ {
+		l[k] = nil
+	}
+	return l[:j]
+}
+
+// expire moves the live and spanning intervals that end (with reach) at or
+// before pos to lingering. If all is true, every one is moved.
+func (c *sweepCache) expire(pos, reach uint32, all bool) {
+	for len(c.ends) > 0 && (all || addSat(c.ends[0].End(), reach) <= pos) {
+		e := heap.Pop(&c.ends).(*entry)
+		if e.dead {
+			continue
+		}
+		e.expired = true
+		c.gone[e.Source()]++
+		c.lingering = append(c.lingering, e)
+	}
+	for len(c.spanning) > 0 && (all || addSat(c.spanning[0].End(), reach) <= pos) {
+		e := heap.Pop(&c.spanning).(*entry)
+		if e.dead {
+			continue
+		}
+		e.expired = true
+		c.lingering = append(c.lingering, e)
+	}
+}
+
+// remove marks e as having left the cache.
+func (c *sweepCache) remove(e *entry) {
+	if !e.expired && !e.span {
+		c.gone[e.Source()]++
+	}
+	e.dead = true
+}
+
+// first returns the earliest query interval that is still in the cache or nil
+// if there is none. less gives the order of the spanning intervals.
+func (c *sweepCache) first(less func(a, b Relatable) bool) Relatable {
+	f, s := c.order.front(), c.spanOrder.front()
+	if s != nil && (f == nil || less(s.Relatable, f.Relatable)) {
+		f = s
+	}
+	if f == nil {
+		return nil
+	}
+	return f.Relatable
+}
+
+// drain calls fn on each query interval in the cache and empties the cache.
+func (c *sweepCache) drain(fn func(Relatable)) {
+	for _, e := range c.order.e[c.order.head:] {
+		if !e.dead {
+			fn(e.Relatable)
+		}
+	}
+	for _, e := range c.spanOrder.e[c.spanOrder.head:] {
+		if !e.dead {
+			fn(e.Relatable)
+		}
+	}
+	*c = sweepCache{seq: c.seq}
+}
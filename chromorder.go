@@ -0,0 +1,234 @@
+package irelate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// ChromOrder orders chromosomes as they appear in a genome file (.fai or
+// .genome) or a sequence dictionary (@SQ lines from a SAM/BAM header or a .dict
+// file, or ##contig lines from a VCF header). Chromosomes are matched with or
+// without a "chr" prefix.
+// Use ChromOrder.Less as the less function sent to IRelate.
+type ChromOrder struct {
+	names   []string
+	lengths []int
+	index   map[string]int
+}
+
+// NewChromOrder returns a ChromOrder that sorts chromosomes in the order given
+// by names. lengths may be nil if the chromosome lengths are not known.
+func NewChromOrder(names []string, lengths []int) *ChromOrder {
+	o := &ChromOrder{names: names, lengths: lengths, index: make(map[string]int, len(names))}
+	for i, n := range names {
+		n = StripChr(n)
+		if _, ok := o.index[n]; !ok {
+			o.index[n] = i
+		}
+	}
+	return o
+}
+
+// Names returns the chromosomes in order.
+func (o *ChromOrder) Names() []string {
+	return o.names
+}
+
+// Length returns the length of chrom or 0 if it is not known.
+func (o *ChromOrder) Length(chrom string) int {
+	i, ok := o.index[StripChr(chrom)]
+	if !ok || i >= len(o.lengths) {
+		return 0
+	}
+	return o.lengths[i]
+}
+
+// Index returns the position of chrom in the order or an *ErrUnknownChrom.
+func (o *ChromOrder) Index(chrom string) (int, error) {
+	i, ok := o.index[StripChr(chrom)]
+	if !ok {
+		return -1, &ErrUnknownChrom{Chrom: chrom}
+	}
+	return i, nil
+}
+
+// Less sorts by chromosome order, then by start, then by end. Chromosomes that
+// are not in the order sort after those that are. Use Check to report them
+// as errors instead.
+func (o *ChromOrder) Less(a, b Relatable) bool {
+	if ac, bc := a.Chrom(), b.Chrom(); ac != bc {
+		ai, aok := o.index[StripChr(ac)]
+		bi, bok := o.index[StripChr(bc)]
+		if aok && bok && ai != bi {
+			return ai < bi
+		}
+		if aok != bok {
+			return aok
+		}
+		if !aok && StripChr(ac) != StripChr(bc) {
+			return StripChr(ac) < StripChr(bc)
+		}
+	}
+	return a.Start() < b.Start() || (a.Start() == b.Start() && a.End() < b.End())
+}
+
+// Check wraps a stream so that Next() returns an *ErrUnknownChrom for
+// an interval on a chromosome that is not in the order. Streams sent to IRelate
+// or the query stream sent to PIRelate can be wrapped this way.
+func (o *ChromOrder) Check(it RelatableIterator) RelatableIterator {
+	return &checkedIterator{RelatableIterator: it, o: o}
+}
+
+type checkedIterator struct {
+	RelatableIterator
+	o    *ChromOrder
+	last string
+}
+
+func (c *checkedIterator) Next() (Relatable, error) {
+	v, err := c.RelatableIterator.Next()
+	if err != nil || v.Chrom() == c.last {
+		return v, err
+	}
+	if _, err := c.o.Index(v.Chrom()); err != nil {
+		return nil, err
+	}
+	c.last = v.Chrom()
+	return v, nil
+}
+
+// ReadGenomeFile reads a .fai or a .genome file where the first column is the
+// chromosome and the second is its length.
+func ReadGenomeFile(r io.Reader) (*ChromOrder, error) {
+	var names []string
+	var lengths []int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("irelate: expected chromosome and length in genome file, got: %s", line)
+		}
+		l, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("irelate: bad length in genome file: %s", line)
+		}
+		names = append(names, fields[0])
+		lengths = append(lengths, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewChromOrder(names, lengths), nil
+}
+
+// ReadSequenceDictionary reads the @SQ lines from a SAM header (e.g. from
+// `samtools view -H`) or a picard .dict file.
+func ReadSequenceDictionary(r io.Reader) (*ChromOrder, error) {
+	return readHeaderOrder(r, "@SQ", func(line string) (string, string) {
+		var name, length string
+		for _, f := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(f, "SN:") {
+				name = f[3:]
+			} else if strings.HasPrefix(f, "LN:") {
+				length = f[3:]
+			}
+		}
+		return name, length
+	})
+}
+
+// ReadVCFContigs reads the ##contig lines from a VCF header. Reading stops at
+// the #CHROM line.
+func ReadVCFContigs(r io.Reader) (*ChromOrder, error) {
+	return readHeaderOrder(r, "##contig=<", func(line string) (string, string) {
+		f := headerFields(strings.TrimSuffix(line[len("##contig=<"):], ">"))
+		return f["ID"], f["length"]
+	})
+}
+
+// headerFields parses the key=value pairs of a structured VCF header line
+// (without the enclosing <>). Commas inside double quotes do not separate
+// pairs, and the quotes are removed from quoted values.
+func headerFields(s string) map[string]string {
+	fields := make(map[string]string)
+	var key string
+	var val strings.Builder
+	inKey, quoted, escaped := true, false, false
+	end := func() {
+		if key != "" {
+			fields[key] = val.String()
+		}
+		key = ""
+		val.Reset()
+		inKey = true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inKey && c == '=':
+			key = strings.TrimSpace(val.String())
+			val.Reset()
+			inKey = false
+		case inKey && c == ',':
+			val.Reset()
+		case inKey:
+			val.WriteByte(c)
+		case escaped:
+			val.WriteByte(c)
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			end()
+		default:
+			val.WriteByte(c)
+		}
+	}
+	if !inKey {
+		end()
+	}
+	return fields
+}
+
+func readHeaderOrder(r io.Reader, prefix string, parse func(string) (string, string)) (*ChromOrder, error) {
+	var names []string
+	var lengths []int
+	rdr := bufio.NewReader(r)
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, prefix) {
+			name, length := parse(line)
+			if name == "" {
+				return nil, fmt.Errorf("irelate: no sequence name in header line: %s", line)
+			}
+			l, _ := strconv.Atoi(length)
+			names = append(names, name)
+			lengths = append(lengths, l)
+		} else if strings.HasPrefix(line, "#CHROM") || (len(line) > 0 && line[0] != '#' && line[0] != '@') {
+			// end of the header.
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("irelate: no %s lines found in header", prefix)
+	}
+	return NewChromOrder(names, lengths), nil
+}
@@ -0,0 +1,106 @@
+package irelate
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadGenomeFile(t *testing.T) {
+	fai := "1\t249250621\t52\t60\t61\n2\t243199373\t253404903\t60\t61\nX\t155270560\t1\t60\t61\nY\t59373566\t1\t60\t61\nMT\t16569\t1\t60\t61\n"
+	o, err := ReadGenomeFile(strings.NewReader(fai))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(o.Names(), ",") != "1,2,X,Y,MT" {
+		t.Errorf("unexpected order: %v", o.Names())
+	}
+	if o.Length("chrMT") != 16569 {
+		t.Errorf("expected length of 16569 for MT, got %d", o.Length("chrMT"))
+	}
+	if !o.Less(iv("Y", 10, 20), iv("MT", 1, 2)) || o.Less(iv("chrMT", 1, 2), iv("chrY", 10, 20)) {
+		t.Error("expected Y before MT")
+	}
+	if !o.Less(iv("2", 10, 20), iv("2", 10, 30)) {
+		t.Error("expected sort by end within a start")
+	}
+
+	if _, err := ReadGenomeFile(strings.NewReader("chr1\tabc\n")); err == nil {
+		t.Error("expected an error for a bad length")
+	}
+}
+
+func TestReadSequenceDictionary(t *testing.T) {
+	hdr := "@HD\tVN:1.0\tSO:coordinate\n@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chrM\tLN:16571\n@SQ\tSN:chr2\tLN:800\n@PG\tID:bwa\nread1\t0\tchr1\t1\n"
+	o, err := ReadSequenceDictionary(strings.NewReader(hdr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(o.Names(), ",") != "chr1,chrM,chr2" {
+		t.Errorf("unexpected order: %v", o.Names())
+	}
+	if i, err := o.Index("M"); i != 1 || err != nil {
+		t.Errorf("expected index 1 for M, got %d, %v", i, err)
+	}
+	if _, err := o.Index("chr3"); err == nil {
+		t.Error("expected error for unknown chromosome")
+	}
+}
+
+func TestReadVCFContigs(t *testing.T) {
+	hdr := `##fileformat=VCFv4.1
+##contig=<ID=1,length=249250621,assembly=b37>
+##contig=<ID=GL000192.1,description="unplaced, \"random\", length=5",length=547496>
+##contig=<ID=X,length=155270560>
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+1	10	.	A	T	50	PASS	.
+`
+	o, err := ReadVCFContigs(strings.NewReader(hdr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(o.Names(), ",") != "1,GL000192.1,X" || o.Length("X") != 155270560 || o.Length("GL000192.1") != 547496 {
+		t.Errorf("unexpected order: %v", o.Names())
+	}
+	if _, err := ReadVCFContigs(strings.NewReader("##fileformat=VCFv4.1\n#CHROM\n")); err == nil {
+		t.Error("expected an error with no contigs")
+	}
+}
+
+func TestChromOrderIRelate(t *testing.T) {
+	// GATK order.
+	o := NewChromOrder([]string{"1", "2", "X", "Y", "MT"}, nil)
+	a := ivs(iv("2", 10, 20), iv("X", 10, 20), iv("MT", 10, 20))
+	b := ivs(iv("chr2", 15, 25), iv("chrX", 5, 15), iv("chrMT", 1, 12))
+	it := IRelate(CheckOverlapPrefix, 0, o.Less, a, b)
+	n := 0
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Related()) != 1 {
+			t.Errorf("expected 1 relation for %s:%d-%d, got %d", r.Chrom(), r.Start(), r.End(), len(r.Related()))
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected 3 intervals, got %d", n)
+	}
+
+	it = IRelate(CheckOverlapPrefix, 0, o.Less, o.Check(ivs(iv("1", 10, 20), iv("GL000192.1", 10, 20))), ivs())
+	var err error
+	for err == nil {
+		_, err = it.Next()
+	}
+	se, ok := err.(*ErrSource)
+	if !ok {
+		t.Fatalf("expected *ErrSource, got %v", err)
+	}
+	if u, ok := se.Err.(*ErrUnknownChrom); !ok || u.Chrom != "GL000192.1" {
+		t.Errorf("expected *ErrUnknownChrom, got %v", se.Err)
+	}
+}
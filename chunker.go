@@ -0,0 +1,183 @@
+package irelate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/brentp/irelate/interfaces"
+)
+
+// maxAdaptiveGap bounds the gap that ends a chunk in adaptive mode when the
+// databases are (so far) empty.
+const maxAdaptiveGap = 1 << 24
+
+// chunkParams determine where a query chunk of PIRelate ends.
+type chunkParams struct {
+	size, maxGap, minGap, slack, gapChunkSize, breakGap int
+}
+
+// ends reports whether a chunk of n intervals on one chromosome ends before an
+// interval that starts gap bases after the start of the last.
+func (p chunkParams) ends(n, gap int) bool {
+	return (n > p.gapChunkSize && gap > p.maxGap) || (gap > p.minGap && n >= p.size) || n >= p.size+p.slack || gap > p.breakGap
+}
+
+// chunker gives the chunkParams for each query chunk of PIRelate. With
+// PIRelateOptions.Adaptive, the size of the chunks and the gap that ends them
+// are updated from the cost of the chunks that have been swept.
+type chunker struct {
+	opts *PIRelateOptions
+	// fixedBreak is set if BreakGap was given rather than derived from MaxGap.
+	fixedBreak bool
+	// pad is the number of bases added to each chunk to query the databases.
+	pad int
+	// obs, if not nil, is sent the records and timings of each chunk.
+	obs Observer
+
+	mu     sync.Mutex
+	chunks int
+	// perQuery is the time (in seconds) to sweep a query interval and density
+	// is the number of database intervals per base. Both are moving averages.
+	perQuery, density float64
+}
+
+// newChunker returns the chunker for opts, which must have its defaults set.
+// fixedBreak is true if BreakGap was set by the caller.
+func newChunker(opts *PIRelateOptions, fixedBreak bool, pad int, obs Observer) *chunker {
+	return &chunker{opts: opts, fixedBreak: fixedBreak, pad: pad, obs: obs}
+}
+
+// params returns the chunkParams for the next chunk.
+func (c *chunker) params() chunkParams {
+	o := c.opts
+	p := chunkParams{size: o.ChunkSize, maxGap: o.MaxGap, minGap: o.MinGap, slack: o.ChunkSlack,
+		gapChunkSize: o.GapChunkSize, breakGap: o.BreakGap}
+	if !o.Adaptive {
+		return p
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chunks == 0 {
+		return p
+	}
+	if c.perQuery > 0 {
+		p.size = int(o.TargetLatency.Seconds() / c.perQuery)
+	} else {
+		p.size = o.MaxChunkSize
+	}
+	p.size = min(max(p.size, o.MinChunkSize), o.MaxChunkSize)
+	// a gap ends a chunk when skipping it saves parsing about GapIntervals
+	// database intervals.
+	p.maxGap = maxAdaptiveGap
+	if c.density > 0 {
+		p.maxGap = min(max(int(float64(o.GapIntervals)/c.density), p.minGap), maxAdaptiveGap)
+	}
+	p.gapChunkSize = min(o.GapChunkSize, o.MinChunkSize)
+	if !c.fixedBreak {
+		p.breakGap = 10 * p.maxGap
+	}
+	return p
+}
+
+// observe records the cost of a chunk with queries intervals over span bases
+// in which dbs database intervals took elapsed to sweep.
+func (c *chunker) observe(queries, span, dbs int, elapsed time.Duration) {
+	const alpha = 0.3
+	perQuery := elapsed.Seconds() / float64(max(queries, 1))
+	density := float64(dbs) / float64(max(span+c.pad, 1))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chunks == 0 {
+		c.perQuery, c.density = perQuery, density
+	} else {
+		c.perQuery += alpha * (perQuery - c.perQuery)
+		c.density += alpha * (density - c.density)
+	}
+	c.chunks++
+}
+
+// chunkCost measures the sweep of one chunk in adaptive mode or when it is
+// observed. A nil *chunkCost measures nothing.
+type chunkCost struct {
+	c             *chunker
+	pc            *pending
+	queries, span int
+	// records counts the intervals read from each database.
+	records []int
+	elapsed time.Duration
+	started time.Time
+	start   time.Time
+}
+
+// countedIt counts the intervals from a database stream.
+type countedIt struct {
+	interfaces.RelatableIterator
+	n *int
+}
+
+func (c countedIt) Next() (interfaces.Relatable, error) {
+	r, err := c.RelatableIterator.Next()
+	if err == nil {
+		*c.n++
+	}
+	return r, err
+}
+
+// track returns the streams of chunk pc (with the databases wrapped so that
+// their intervals are counted) and the chunkCost to measure its sweep. If the
+// chunker is not adaptive or observed, the streams are returned as they are.
+func (c *chunker) track(pc *pending, streams []interfaces.RelatableIterator) ([]interfaces.RelatableIterator, *chunkCost) {
+	if !c.opts.Adaptive && c.obs == nil {
+		return streams, nil
+	}
+	cost := &chunkCost{c: c, pc: pc, records: make([]int, len(streams)), started: time.Now()}
+	if s, ok := streams[0].(*sliceIt); ok && len(s.slice) > 0 {
+		cost.queries = len(s.slice)
+		end := 0
+		for _, r := range s.slice {
+			end = max(end, int(r.End()))
+		}
+		cost.span = end - int(s.slice[0].Start())
+	}
+	wrapped := make([]interfaces.RelatableIterator, len(streams))
+	wrapped[0] = streams[0]
+	for i, s := range streams[1:] {
+		wrapped[i+1] = countedIt{s, &cost.records[i+1]}
+	}
+	return wrapped, cost
+}
+
+// begin and end bracket each call to Next on the sweep.
+func (cc *chunkCost) begin() {
+	if cc != nil {
+		cc.start = time.Now()
+	}
+}
+
+func (cc *chunkCost) end() {
+	if cc != nil {
+		cc.elapsed += time.Since(cc.start)
+	}
+}
+
+// done records the cost once the sweep of the chunk is exhausted.
+func (cc *chunkCost) done() {
+	if cc == nil {
+		return
+	}
+	if cc.c.opts.Adaptive {
+		dbs := 0
+		for _, n := range cc.records {
+			dbs += n
+		}
+		cc.c.observe(cc.queries, cc.span, dbs, cc.elapsed)
+	}
+	if obs := cc.c.obs; obs != nil {
+		pc := cc.pc
+		obs.ChunkDone(pc.info, ChunkTimings{Query: pc.query, Wait: cc.started.Sub(pc.created), Sweep: cc.elapsed})
+		obs.Records(0, pc.info.Queries)
+		for i, n := range cc.records[1:] {
+			obs.Records(i+1, n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package irelate
+
+import "fmt"
+
+// ErrUnsorted is returned from Next() when a stream sent to IRelate is not
+// sorted. Cur is the start of an interval that followed an interval starting at
+// Prev on the same chromosome.
+type ErrUnsorted struct {
+	Source uint32
+	Chrom  string
+	Prev   uint32
+	Cur    uint32
+}
+
+func (e *ErrUnsorted) Error() string {
+	return fmt.Sprintf("irelate: intervals out of order within file: starts at: %d and %d on %s from source: %d", e.Prev, e.Cur, e.Chrom, e.Source)
+}
+
+// ErrSource is returned from Next() when one of the streams sent to IRelate
+// returns an error other than io.EOF. Err is the error from the stream.
+type ErrSource struct {
+	Source uint32
+	Err    error
+}
+
+func (e *ErrSource) Error() string {
+	return fmt.Sprintf("irelate: error reading from source: %d: %s", e.Source, e.Err)
+}
+
+// Unwrap returns the error from the stream.
+func (e *ErrSource) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnknownChrom is returned for a chromosome that is not in a ChromOrder.
+type ErrUnknownChrom struct {
+	Chrom string
+}
+
+func (e *ErrUnknownChrom) Error() string {
+	return fmt.Sprintf("irelate: chromosome %s not found in chromosome order", e.Chrom)
+}
+
+// ErrChromOrder is returned when the chromosomes are not in the same order in
+// all sources, or are not in the order expected by the less function. Source is
+// the source that returned to Chrom after another chromosome was seen, or, from
+// ReconcileOrders, the second of 2 sources whose orders disagree as shown in Diff.
+type ErrChromOrder struct {
+	Source int
+	Chrom  string
+	Diff   string
+}
+
+func (e *ErrChromOrder) Error() string {
+	if e.Diff != "" {
+		return fmt.Sprintf("irelate: chromosome order differs between sources at %s:\n%s", e.Chrom, e.Diff)
+	}
+	return fmt.Sprintf("irelate: chromosomes out of order. saw %s from source: %d after another chromosome", e.Chrom, e.Source)
+}
+
+// ErrType is returned by Relations.Next and RelatedAs when an interval from
+// Source is not of the requested type.
+type ErrType struct {
+	Source uint32
+	Want   string
+	Got    string
+}
+
+func (e *ErrType) Error() string {
+	return fmt.Sprintf("irelate: expected %s from source: %d, got %s", e.Want, e.Source, e.Got)
+}
@@ -0,0 +1,74 @@
+package irelate
+
+import (
+	"expvar"
+	"strconv"
+)
+
+// ExpvarObserver is an Observer that publishes the events it receives as
+// expvar variables (served at /debug/vars) in a map with these keys:
+//
+//	chunks_created, chunks_done     the chunks of PIRelate
+//	queries                         the query intervals in the chunks
+//	records                         a map of the records read from each source
+//	bases_parsed, bases_skipped     see ChunkInfo
+//	query_ns, wait_ns, sweep_ns     the sums of the ChunkTimings
+//	queue_reading, queue_sweeping,
+//	queue_output, in_flight         the last QueueDepths
+//	chrom                           the chromosome that has been reached
+type ExpvarObserver struct {
+	m       *expvar.Map
+	records *expvar.Map
+	chrom   *expvar.String
+	// the queue depths are replaced rather than added to.
+	reading, sweeping, output, inFlight *expvar.Int
+}
+
+// NewExpvarObserver publishes its variables in the expvar.Map called name. If
+// a map of that name has already been published, it is cleared and reused.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if ok {
+		m.Init()
+	} else {
+		m = expvar.NewMap(name)
+	}
+	e := &ExpvarObserver{m: m, records: new(expvar.Map).Init(), chrom: new(expvar.String),
+		reading: new(expvar.Int), sweeping: new(expvar.Int), output: new(expvar.Int), inFlight: new(expvar.Int)}
+	m.Set("records", e.records)
+	m.Set("chrom", e.chrom)
+	m.Set("queue_reading", e.reading)
+	m.Set("queue_sweeping", e.sweeping)
+	m.Set("queue_output", e.output)
+	m.Set("in_flight", e.inFlight)
+	return e
+}
+
+func (e *ExpvarObserver) ChunkCreated(c ChunkInfo) {
+	e.m.Add("chunks_created", 1)
+	e.m.Add("queries", int64(c.Queries))
+	e.m.Add("bases_parsed", int64(c.Parsed))
+	e.m.Add("bases_skipped", int64(c.Skipped))
+}
+
+func (e *ExpvarObserver) ChunkDone(c ChunkInfo, t ChunkTimings) {
+	e.m.Add("chunks_done", 1)
+	e.m.Add("query_ns", int64(t.Query))
+	e.m.Add("wait_ns", int64(t.Wait))
+	e.m.Add("sweep_ns", int64(t.Sweep))
+}
+
+func (e *ExpvarObserver) Records(source int, n int) {
+	e.records.Add(strconv.Itoa(source), int64(n))
+}
+
+func (e *ExpvarObserver) Queues(q QueueDepths) {
+	e.reading.Set(int64(q.Reading))
+	e.sweeping.Set(int64(q.Sweeping))
+	e.output.Set(int64(q.Output))
+	e.inFlight.Set(q.InFlight)
+}
+
+func (e *ExpvarObserver) Chrom(prev, next string) {
+	e.chrom.Set(next)
+}
@@ -0,0 +1,354 @@
+package irelate
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// unencodedSize is the size counted against the memLimit of SortedIterator for
+// an interval that does not implement Encodable.
+const unencodedSize = 64
+
+// mergeFanIn is the greatest number of runs that SortedIterator merges (and so
+// keeps open) at once. With more, they are merged mergeFanIn at a time into
+// longer runs first.
+const mergeFanIn = 64
+
+// SortedIterator returns the intervals from it sorted by less so that unsorted
+// files can be sent to IRelate. Intervals are sorted in memory until their
+// size reaches memLimit bytes, which must be > 0; each such run is then written
+// to a temporary file in tmpDir (os.TempDir() if it is empty) and the runs are
+// merged, at most 64 at a time. The size of an interval is its encoded size
+// (i.e. its size on disk), so each interval is encoded once as it is read and
+// again if it is written. The intervals from the parsers package implement
+// Encodable; others count as 64 bytes each and can only be sorted in memory,
+// so an error is returned if they do not fit. it is read to the end and closed
+// before SortedIterator returns. Intervals that are equal by less keep their
+// order.
+func SortedIterator(it RelatableIterator, less func(a, b Relatable) bool, tmpDir string, memLimit int) (RelatableIterator, error) {
+	defer it.Close()
+	if memLimit <= 0 {
+		return nil, fmt.Errorf("irelate: memLimit must be > 0, got %d", memLimit)
+	}
+	s := &extSorter{less: less, tmpDir: tmpDir}
+	var size int
+	var buf []byte
+	// encodable is set from the first interval.
+	encodable := true
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if s.codec == nil && encodable {
+			if e, ok := r.(Encodable); ok {
+				if s.codec, err = e.NewCodec(); err != nil {
+					s.Close()
+					return nil, err
+				}
+			} else {
+				encodable = false
+			}
+		}
+		n := unencodedSize
+		if s.codec != nil {
+			if buf, err = s.codec.Encode(buf[:0], r); err != nil {
+				s.Close()
+				return nil, err
+			}
+			n = len(buf)
+		}
+		s.recs = append(s.recs, r)
+		size += n
+		if size >= memLimit {
+			if s.codec == nil {
+				s.Close()
+				return nil, fmt.Errorf("irelate: can not sort %T on disk: it does not implement Encodable", r)
+			}
+			if err := s.spill(); err != nil {
+				s.Close()
+				return nil, err
+			}
+			size = 0
+		}
+	}
+	sort.SliceStable(s.recs, func(i, j int) bool { return less(s.recs[i], s.recs[j]) })
+	if len(s.runs) == 0 {
+		return sliceToIterator(s.recs), nil
+	}
+	// leave room for the run in memory.
+	for len(s.runs) >= mergeFanIn {
+		if err := s.mergePass(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	// what remains in memory is the last run.
+	runs, err := s.open(s.runs)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if len(s.recs) > 0 {
+		runs = append(runs, &run{recs: s.recs})
+	}
+	s.recs = nil
+	if err := s.start(runs); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// extSorter merges the sorted runs written by SortedIterator.
+type extSorter struct {
+	less   func(a, b Relatable) bool
+	tmpDir string
+	codec  Codec
+	recs   []Relatable
+	// runs holds the names of the temporary files. Only those in the merge
+	// are open.
+	runs []string
+	q    runQueue
+	buf  []byte
+}
+
+// create makes a temporary file for a run and adds it to s.runs.
+func (s *extSorter) create() (*os.File, error) {
+	f, err := os.CreateTemp(s.tmpDir, "irelate-sort-")
+	if err != nil {
+		return nil, err
+	}
+	s.runs = append(s.runs, f.Name())
+	return f, nil
+}
+
+// write writes r to w with its length.
+func (s *extSorter) write(w *bufio.Writer, r Relatable) error {
+	var err error
+	if s.buf, err = s.codec.Encode(s.buf[:0], r); err != nil {
+		return err
+	}
+	var n [binary.MaxVarintLen64]byte
+	if _, err = w.Write(n[:binary.PutUvarint(n[:], uint64(len(s.buf)))]); err != nil {
+		return err
+	}
+	_, err = w.Write(s.buf)
+	return err
+}
+
+// spill sorts the intervals in memory and writes them to a temporary file.
+func (s *extSorter) spill() error {
+	sort.SliceStable(s.recs, func(i, j int) bool { return s.less(s.recs[i], s.recs[j]) })
+	f, err := s.create()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for i, r := range s.recs {
+		if err = s.write(w, r); err != nil {
+			return err
+		}
+		s.recs[i] = nil
+	}
+	s.recs = s.recs[:0]
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// mergePass merges the runs mergeFanIn at a time, keeping their order.
+func (s *extSorter) mergePass() error {
+	names := s.runs
+	s.runs = nil
+	for len(names) > 0 {
+		n := min(mergeFanIn, len(names))
+		if err := s.merge(names[:n]); err != nil {
+			// the rest are removed by Close.
+			s.runs = append(s.runs, names...)
+			return err
+		}
+		for _, name := range names[:n] {
+			os.Remove(name)
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+// merge writes the intervals from the runs in names to a new run.
+func (s *extSorter) merge(names []string) error {
+	runs, err := s.open(names)
+	if err != nil {
+		return err
+	}
+	defer s.closeRuns()
+	if err = s.start(runs); err != nil {
+		return err
+	}
+	f, err := s.create()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for len(s.q.runs) > 0 {
+		r := heap.Pop(&s.q).(*run)
+		if err = s.write(w, r.cur); err != nil {
+			return err
+		}
+		if err = s.push(r); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// open opens the runs in names.
+func (s *extSorter) open(names []string) ([]*run, error) {
+	runs := make([]*run, 0, len(names)+1)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			for _, r := range runs {
+				r.f.Close()
+			}
+			return nil, err
+		}
+		runs = append(runs, &run{f: f, r: bufio.NewReader(f)})
+	}
+	return runs, nil
+}
+
+// start fills the queue with the first interval of each of runs.
+func (s *extSorter) start(runs []*run) error {
+	s.q = runQueue{less: s.less}
+	for i, r := range runs {
+		r.idx = i
+		if err := s.push(r); err != nil {
+			for _, r := range runs[i:] {
+				if r.f != nil {
+					r.f.Close()
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// push reads the next interval from r and adds r to the queue unless it is
+// exhausted, in which case its file is closed.
+func (s *extSorter) push(r *run) error {
+	if r.f == nil {
+		if len(r.recs) == 0 {
+			return nil
+		}
+		r.cur, r.recs = r.recs[0], r.recs[1:]
+		heap.Push(&s.q, r)
+		return nil
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return r.f.Close()
+	}
+	if err == nil {
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r.r, data); err == nil {
+			r.cur, err = s.codec.Decode(data)
+		}
+	}
+	if err != nil {
+		r.f.Close()
+		return err
+	}
+	heap.Push(&s.q, r)
+	return nil
+}
+
+func (s *extSorter) Next() (Relatable, error) {
+	if len(s.q.runs) == 0 {
+		s.Close()
+		return nil, io.EOF
+	}
+	r := heap.Pop(&s.q).(*run)
+	cur := r.cur
+	if err := s.push(r); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return cur, nil
+}
+
+// closeRuns closes the files of the runs in the queue.
+func (s *extSorter) closeRuns() {
+	for _, r := range s.q.runs {
+		if r.f != nil {
+			r.f.Close()
+		}
+	}
+	s.q.runs = nil
+}
+
+// Close removes the temporary files.
+func (s *extSorter) Close() error {
+	s.closeRuns()
+	var err error
+	for _, name := range s.runs {
+		if e := os.Remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.runs = nil
+	return err
+}
+
+// run is a sorted run in a temporary file or, for the last run, in memory.
+type run struct {
+	f    *os.File
+	r    *bufio.Reader
+	recs []Relatable
+	cur  Relatable
+	// idx keeps intervals that are equal by less in the order they were read.
+	idx int
+}
+
+// runQueue is a min-heap of runs ordered by their current interval.
+type runQueue struct {
+	runs []*run
+	less func(a, b Relatable) bool
+}
+
+func (q runQueue) Len() int { return len(q.runs) }
+func (q runQueue) Less(i, j int) bool {
+	a, b := q.runs[i], q.runs[j]
+	if q.less(a.cur, b.cur) {
+		return true
+	}
+	return !q.less(b.cur, a.cur) && a.idx < b.idx
+}
+func (q runQueue) Swap(i, j int)       { q.runs[i], q.runs[j] = q.runs[j], q.runs[i] }
+func (q *runQueue) Push(i interface{}) { q.runs = append(q.runs, i.(*run)) }
+func (q *runQueue) Pop() interface{} {
+	old := q.runs
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	q.runs = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,161 @@
+package irelate
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+	"github.com/brentp/vcfgo"
+)
+
+func TestSortedIterator(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	rels := make([]Relatable, 5000)
+	for i := range rels {
+		s := uint32(rng.Intn(10000))
+		chrom := fmt.Sprintf("chr%d", 1+rng.Intn(3))
+		line := fmt.Sprintf("%s\t%d\t%d\tname%d", chrom, s, s+uint32(rng.Intn(100)), i)
+		rels[i], _ = parsers.IntervalFromBedLine([]byte(line))
+	}
+	// 200 bytes gives more runs than are merged at once.
+	for _, limit := range []int{1 << 30, 10000, 200} {
+		dir := t.TempDir()
+		it, err := SortedIterator(ivs(rels...), Less, dir, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files, _ := os.ReadDir(dir); (limit < 1<<30) != (len(files) > 0) || len(files) >= mergeFanIn {
+			t.Errorf("limit %d: unexpected %d temporary files", limit, len(files))
+		}
+		var last Relatable
+		n := 0
+		for r, err := it.Next(); err == nil; r, err = it.Next() {
+			if last != nil && Less(r, last) {
+				t.Fatalf("limit %d: out of order: %s after %s", limit, r, last)
+			}
+			if f := r.(*parsers.Interval).Fields; len(f) != 4 || string(f[0]) != r.Chrom() {
+				t.Fatalf("limit %d: fields not kept: %q", limit, f)
+			}
+			if last != nil && !Less(last, r) && name(r) < name(last) {
+				t.Fatalf("limit %d: equal intervals out of input order: %s after %s", limit, r, last)
+			}
+			last = r
+			n++
+		}
+		if n != len(rels) {
+			t.Errorf("limit %d: expected %d intervals, got %d", limit, len(rels), n)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("limit %d: expected temporary files to be removed, found %d", limit, len(files))
+		}
+	}
+
+	// intervals that do not implement Encodable are sorted if they fit in memory.
+	plain := []Relatable{AsRelatable(iv("chr1", 5, 6).(SIPosition)), AsRelatable(iv("chr1", 1, 2).(SIPosition))}
+	it, err := SortedIterator(ivs(plain...), Less, t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := it.Next(); err != nil || r.Start() != 1 {
+		t.Errorf("expected chr1:1-2 first, got %v, %v", r, err)
+	}
+
+	_, err = SortedIterator(ivs(AsRelatable(iv("chr1", 1, 2).(SIPosition))), Less, t.TempDir(), 1)
+	if err == nil {
+		t.Error("expected an error for an interval that does not implement Encodable")
+	}
+	if _, err = SortedIterator(ivs(rels...), Less, t.TempDir(), 0); err == nil {
+		t.Error("expected an error for a memLimit of 0")
+	}
+}
+
+// name returns the index in the name column of an interval from TestSortedIterator.
+func name(r Relatable) int {
+	var i int
+	fmt.Sscanf(string(r.(*parsers.Interval).Fields[3]), "name%d", &i)
+	return i
+}
+
+// spilled returns rels, which must be sorted by Less, after writing them to disk and
+// merging them back with SortedIterator.
+func spilled(t *testing.T, rels []Relatable) []Relatable {
+	dir := t.TempDir()
+	it, err := SortedIterator(ivs(rels...), Less, dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if files, _ := os.ReadDir(dir); len(files) < 2 {
+		t.Fatalf("expected intervals to be spilled to several files, found %d", len(files))
+	}
+	var out []Relatable
+	for r, err := it.Next(); err == nil; r, err = it.Next() {
+		out = append(out, r)
+	}
+	if len(out) != len(rels) {
+		t.Fatalf("expected %d intervals, got %d", len(rels), len(out))
+	}
+	return out
+}
+
+func TestSortedIteratorBam(t *testing.T) {
+	bam, err := parsers.NewBamIterator("data/ex.bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []Relatable
+	for r, err := bam.Next(); err == nil; r, err = bam.Next() {
+		rels = append(rels, r)
+	}
+	bam.Close()
+	sort.SliceStable(rels, func(i, j int) bool { return Less(rels[i], rels[j]) })
+	for i, r := range spilled(t, rels) {
+		a, b := rels[i].(*parsers.Bam), r.(*parsers.Bam)
+		if a.Chromosome != b.Chromosome || !reflect.DeepEqual(a.Record, b.Record) {
+			t.Fatalf("record %d changed:\n%v\n%v", i, a.Record, b.Record)
+		}
+	}
+}
+
+const spillVCF = `##fileformat=VCFv4.1
+##INFO=<ID=DP,Number=1,Type=Integer,Description="Depth">
+##FORMAT=<ID=GT,Number=1,Type=String,Description="Genotype">
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO	FORMAT	s1	s2
+1	100	rs1	A	G	50	PASS	DP=10	GT	0/1	1/1
+1	100	rs2	A	T,C	20	q10	DP=3	GT	0/0	./.
+1	250	.	ACGT	A	.	.	DP=7	GT	0/1	0/0
+2	15	rs3	C	CT	99	PASS	.	GT	1/1	0/1
+X	9	.	G	<DEL>	40	PASS	DP=12	GT	0/0	0/1
+`
+
+func TestSortedIteratorVariant(t *testing.T) {
+	var rels []Relatable
+	for i := 0; i < 40; i++ {
+		vcf, _, err := parsers.VCFIterator(strings.NewReader(spillVCF))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r, err := vcf.Next(); err == nil; r, err = vcf.Next() {
+			rels = append(rels, r)
+		}
+		vcf.Close()
+	}
+	sort.SliceStable(rels, func(i, j int) bool { return Less(rels[i], rels[j]) })
+	for i, r := range spilled(t, rels) {
+		a, b := rels[i].(*parsers.Variant), r.(*parsers.Variant)
+		if a.String() != b.String() || a.Chrom() != b.Chrom() || a.Start() != b.Start() || a.End() != b.End() ||
+			a.Id() != b.Id() || a.Ref() != b.Ref() || !reflect.DeepEqual(a.Alt(), b.Alt()) {
+			t.Fatalf("variant %d changed:\n%s\n%s", i, a, b)
+		}
+	}
+
+	if _, err := parsers.NewVariant(&vcfgo.Variant{}, 0, nil).NewCodec(); err == nil {
+		t.Error("expected an error for a Variant without a header")
+	}
+}
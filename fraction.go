@@ -0,0 +1,77 @@
+package irelate
+
+import (
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// OverlapFraction relates intervals that overlap by at least a fraction of
+// their lengths, as with the -f, -F, -r and -e options to bedtools intersect.
+// Use its Check method as the checkRelated argument to IRelate. IRelate only
+// evicts an interval once a later interval starts at or after its end, so
+// intervals that fail the fraction test remain available to later ones.
+type OverlapFraction struct {
+	// A is the minimum fraction of the query interval that must be overlapped.
+	A float64
+	// B is the minimum fraction of the database interval that must be overlapped.
+	B float64
+	// Reciprocal requires that A is met for both intervals. B is ignored.
+	Reciprocal bool
+	// Either relates the intervals if A or B is met rather than both. A fraction
+	// of 0 is not met unless both are 0.
+	Either bool
+	// Query is the source of the query intervals (the relativeTo argument to
+	// IRelate). If neither or both intervals are from Query, the first argument
+	// to Check is the query.
+	Query uint32
+}
+
+// CheckOverlapFraction returns a checkRelated function that requires that
+// fracA of the query (from source 0) and fracB of the database interval overlap.
+func CheckOverlapFraction(fracA, fracB float64) func(a, b Relatable) bool {
+	return (&OverlapFraction{A: fracA, B: fracB}).Check
+}
+
+// CheckReciprocalOverlap returns a checkRelated function that requires that frac
+// of each interval overlaps the other.
+func CheckReciprocalOverlap(frac float64) func(a, b Relatable) bool {
+	return (&OverlapFraction{A: frac, Reciprocal: true}).Check
+}
+
+// overlapLen returns the number of bases shared by a and b.
+func overlapLen(a, b IPosition) uint32 {
+	s, e := a.Start(), a.End()
+	if b.Start() > s {
+		s = b.Start()
+	}
+	if b.End() < e {
+		e = b.End()
+	}
+	if e <= s {
+		return 0
+	}
+	return e - s
+}
+
+// covers is true if at least frac of r is overlapped by n bases.
+func covers(r IPosition, n uint32, frac float64) bool {
+	return frac <= 0 || float64(n) >= frac*float64(r.End()-r.Start())
+}
+
+// Check returns true if a and b overlap by the required fractions.
+func (o *OverlapFraction) Check(a, b Relatable) bool {
+	if !OverlapsPosition(a, b) {
+		return false
+	}
+	if b.Source() == o.Query && a.Source() != o.Query {
+		a, b = b, a
+	}
+	n := overlapLen(a, b)
+	fb := o.B
+	if o.Reciprocal {
+		fb = o.A
+	}
+	if o.Either && (o.A > 0 || fb > 0) {
+		return (o.A > 0 && covers(a, n, o.A)) || (fb > 0 && covers(b, n, fb))
+	}
+	return covers(a, n, o.A) && covers(b, n, fb)
+}
@@ -0,0 +1,54 @@
+package irelate
+
+import "testing"
+
+func TestOverlapFraction(t *testing.T) {
+	// a is 100 bases, b is 40 bases and they share 20.
+	a, b := iv("chr1", 0, 100), iv("chr1", 80, 120)
+	b.SetSource(1)
+	for _, tt := range []struct {
+		o   OverlapFraction
+		exp bool
+	}{
+		{OverlapFraction{}, true},
+		{OverlapFraction{A: 0.2}, true},
+		{OverlapFraction{A: 0.21}, false},
+		{OverlapFraction{B: 0.5}, true},
+		{OverlapFraction{B: 0.6}, false},
+		{OverlapFraction{A: 0.2, B: 0.5}, true},
+		{OverlapFraction{A: 0.5, B: 0.5}, false},
+		{OverlapFraction{A: 0.5, B: 0.5, Either: true}, true},
+		{OverlapFraction{A: 0.5, Either: true}, false},
+		{OverlapFraction{A: 0.2, Reciprocal: true}, true},
+		{OverlapFraction{A: 0.3, Reciprocal: true}, false},
+		// b is the query.
+		{OverlapFraction{A: 0.5, Query: 1}, true},
+		{OverlapFraction{B: 0.5, Query: 1}, false},
+	} {
+		if got := tt.o.Check(a, b); got != tt.exp {
+			t.Errorf("%+v: expected %v, got %v", tt.o, tt.exp, got)
+		}
+		// the order of the arguments does not matter.
+		if got := tt.o.Check(b, a); got != tt.exp {
+			t.Errorf("%+v (swapped): expected %v, got %v", tt.o, tt.exp, got)
+		}
+	}
+	if (&OverlapFraction{}).Check(a, iv("chr1", 100, 200)) {
+		t.Errorf("expected adjacent intervals not to be related")
+	}
+}
+
+func TestOverlapFractionEviction(t *testing.T) {
+	// the first two database intervals fail the test but the query must still
+	// be related to the third.
+	q := iv("chr1", 0, 1000)
+	it := IRelate(CheckOverlapFraction(0, 0.9), 0, Less, ivs(q),
+		ivs(iv("chr1", 0, 2000), iv("chr1", 500, 1500), iv("chr1", 600, 700)))
+	r, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel := r.Related(); len(rel) != 1 || rel[0].Start() != 600 {
+		t.Errorf("expected relation to chr1:600-700, got %v", rel)
+	}
+}
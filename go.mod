@@ -0,0 +1,15 @@
+module github.com/brentp/irelate
+
+go 1.23
+
+require (
+	github.com/biogo/hts v1.4.5
+	github.com/brentp/bix v0.0.0-20190718183222-fdf6e5ba7e9a
+	github.com/brentp/vcfgo v0.0.0-20190824021635-6d0e4b6e8fee
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+)
+
+require (
+	github.com/kr/pretty v0.2.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+)
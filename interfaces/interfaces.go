@@ -19,6 +19,21 @@ type Queryable interface {
 	Query(region IPosition) (RelatableIterator, error)
 }
 
+// Namer is implemented by indexed files (e.g. tabix or BAI) that know the names
+// of their sequences. Names() returns them in the order they appear in the file.
+type Namer interface {
+	Names() []string
+}
+
+// Offsetter is implemented by indexed files (e.g. BAI or tabix) that can tell
+// from their index how far into the file a region is. Offsets returns the
+// first and last (compressed) file offsets of the records in chrom:start-end;
+// ok is false if the index has none. Size returns the size of the file.
+type Offsetter interface {
+	Offsets(chrom string, start, end int) (first, last int64, ok bool)
+	Size() int64
+}
+
 // IPosition allows accessing positional interface for genomic types.
 type IPosition interface {
 	Chrom() string
@@ -32,6 +47,12 @@ type CIFace interface {
 	CIEnd() (uint32, uint32, bool)
 }
 
+// Stranded is implemented by Relatables that know their strand. Strand()
+// returns '+', '-' or '.' if the strand is not known.
+type Stranded interface {
+	Strand() byte
+}
+
 // Relatable provides all the methods for irelate to function.
 // See Interval in parsers/interval.go for a class that satisfies this interface.
 // Related() likely returns and AddRelated() likely appends to a slice of
@@ -45,6 +66,55 @@ type Relatable interface {
 	SetSource(source uint32)
 }
 
+// Side gives the position of a related interval relative to another.
+type Side int8
+
+const (
+	// Before means the related interval ends at or before the start of the other.
+	Before Side = -1
+	// Overlapping means the intervals overlap.
+	Overlapping Side = 0
+	// After means the related interval starts at or after the end of the other.
+	After Side = 1
+)
+
+// Edge describes the relation from an interval to a related interval.
+type Edge struct {
+	Related Relatable
+	// Overlap is the number of bases shared by the intervals.
+	Overlap uint32
+	// Distance is the number of bases between the intervals. It is negative
+	// when Related is Before the interval and 0 when they overlap.
+	Distance int64
+	Side     Side
+}
+
+// EdgeRelatable is implemented by Relatables that keep the geometry of their
+// relations. When it is implemented, irelate calls AddRelatedEdge instead of
+// AddRelated; the Related field of the Edge must then be in Related().
+type EdgeRelatable interface {
+	Relatable
+	AddRelatedEdge(Edge)
+	RelatedEdges() []Edge
+}
+
+// Codec encodes Relatables of one type so that they can be written to a
+// temporary file and read back (as by irelate.SortedIterator). A Codec is used
+// for a single stream and may keep state between calls.
+type Codec interface {
+	// Encode appends the encoding of r to dst.
+	Encode(dst []byte, r Relatable) ([]byte, error)
+	// Decode returns the Relatable encoded in data. It may keep data.
+	Decode(data []byte) (Relatable, error)
+}
+
+// Encodable is implemented by Relatables that can be written to disk.
+// NewCodec returns a Codec for intervals of the same type as the receiver or
+// an error if they can not be encoded (e.g. a VCF record without its header).
+type Encodable interface {
+	NewCodec() (Codec, error)
+}
+
 // Info must implement stuff to get info out of a variant info field.
 type Info interface {
 	Get(key string) (interface{}, error)
@@ -79,6 +149,7 @@ type SIPosition interface {
 // trun an IPosition into an IRelatalbe
 type RelWrap struct {
 	related []Relatable
+	edges   []Edge
 	source  uint32
 }
 
@@ -99,6 +170,17 @@ func (w *RelWrap) Related() []Relatable {
 	return w.related
 }
 
+// AddRelatedEdge adds e.Related to Related() and keeps e.
+func (w *RelWrap) AddRelatedEdge(e Edge) {
+	w.AddRelated(e.Related)
+	w.edges = append(w.edges, e)
+}
+
+// RelatedEdges returns the edges in the same order as Related().
+func (w *RelWrap) RelatedEdges() []Edge {
+	return w.edges
+}
+
 type VarWrap struct {
 	IVariant
 	*RelWrap
@@ -3,10 +3,9 @@ package irelate
 
 import (
 	"container/heap"
-	"fmt"
+	"context"
 	"io"
-	"log"
-	"os"
+	"sort"
 
 	. "github.com/brentp/irelate/interfaces"
 )
@@ -14,32 +13,83 @@ import (
 // Set relativeTo so SelfRelations constant to allow reporting overlaps within a stream
 const SelfRelations = -2
 
-func relate(a Relatable, b Relatable, relativeTo int) {
-	if relativeTo == SelfRelations {
-		a.AddRelated(b)
-		b.AddRelated(a)
+func relate(a Relatable, b Relatable, m *RelationMatrix) {
+	if m.Relates(a.Source(), b.Source()) {
+		addRelated(a, b)
+	}
+	if m.Relates(b.Source(), a.Source()) {
+		addRelated(b, a)
+	}
+}
+
+// Mode determines which query intervals IRelateMode sends.
+type Mode int
+
+const (
+	// ReportAll sends every query interval with all of its related intervals.
+	ReportAll Mode = iota
+	// ReportUnrelated sends only the query intervals that are not related to
+	// anything (as with bedtools intersect -v).
+	ReportUnrelated
+	// ReportFirst sends only the query intervals that are related to something,
+	// each with one related interval (as with bedtools intersect -u).
+	ReportFirst
+)
+
+// addRelated adds b to a, with the Edge if a is an EdgeRelatable.
+func addRelated(a Relatable, b Relatable) {
+	if e, ok := a.(EdgeRelatable); ok {
+		e.AddRelatedEdge(NewEdge(a, b))
 		return
 	}
-	if a.Source() != b.Source() {
-		if relativeTo == -1 {
-			a.AddRelated(b)
-			b.AddRelated(a)
-		} else {
-			if uint32(relativeTo) == a.Source() {
-				a.AddRelated(b)
-			}
-			if uint32(relativeTo) == b.Source() {
-				b.AddRelated(a)
-			}
-		}
+	a.AddRelated(b)
+}
+
+// NewEdge returns the Edge from a to b.
+func NewEdge(a IPosition, b Relatable) Edge {
+	e := Edge{Related: b, Overlap: overlapLen(a, b)}
+	d, dir := distance(a, b)
+	switch dir {
+	case Upstream:
+		e.Distance, e.Side = -int64(d), Before
+	case Downstream:
+		e.Distance, e.Side = int64(d), After
+	default:
+		e.Side = Overlapping
 	}
+	return e
 }
 
+// Less sorts by chromosome, then by start, then by end.
 func Less(a Relatable, b Relatable) bool {
 	if a.Chrom() != b.Chrom() {
 		return a.Chrom() < b.Chrom()
 	}
-	return a.Start() < b.Start() // || (a.Start() == b.Start() && a.End() < b.End())
+	return a.Start() < b.Start() || (a.Start() == b.Start() && a.End() < b.End())
+}
+
+// TotalOrder returns a less function that sorts by less (which determines the
+// order of chromosomes and starts) and then breaks ties by end, by source and
+// finally by key, which may be nil. IRelate uses TotalOrder(less, nil) to merge
+// the streams and to send the query intervals so that intervals that are equal
+// by less are always sent in the same order. Send a key (e.g. comparing the
+// name column) to order intervals that have the same position and source.
+func TotalOrder(less func(a, b Relatable) bool, key func(a, b Relatable) bool) func(a, b Relatable) bool {
+	return func(a, b Relatable) bool {
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		if a.End() != b.End() {
+			return a.End() < b.End()
+		}
+		if a.Source() != b.Source() {
+			return a.Source() < b.Source()
+		}
+		return key != nil && key(a, b)
+	}
 }
 
 // 1, 2, 3 ... 9, 10, 11...
@@ -59,7 +109,9 @@ func LessPrefix(a Relatable, b Relatable) bool {
 	return a.Start() < b.Start() || (a.Start() == b.Start() && a.End() < b.End())
 }
 
-// CheckRelatedByOverlap returns true if Relatables overlap.
+// CheckRelatedByOverlap returns true if Relatables overlap. As in the sweep, a
+// must not start after b. A zero-length interval is related as with
+// ZeroLengthContained.
 func CheckRelatedByOverlap(a Relatable, b Relatable) bool {
 	return (b.Start() < a.End()) && (b.Chrom() == a.Chrom())
 	// note with distance == 0 this just overlap.
@@ -75,9 +127,86 @@ func CheckOverlapPrefix(a Relatable, b Relatable) bool {
 	return false
 }
 
+// ZeroLength determines whether a zero-length interval (Start() == End(), e.g.
+// an insertion site or a TSS given as a point) is related to the intervals
+// around it. A zero-length interval at p is the point between bases p-1 and p.
+type ZeroLength int
+
+const (
+	// ZeroLengthNone relates a zero-length interval to nothing.
+	ZeroLengthNone ZeroLength = iota
+	// ZeroLengthContained relates a zero-length interval at p to the intervals
+	// that cover the bases on both sides of it (start < p < end). This is the
+	// behavior of CheckRelatedByOverlap.
+	ZeroLengthContained
+	// ZeroLengthAdjacent also relates a zero-length interval at p to the
+	// intervals that start or end at p and to the other zero-length intervals
+	// at p (start <= p <= end).
+	ZeroLengthAdjacent
+)
+
+// CheckRelatedByOverlapZeroLength returns a function that relates intervals
+// that overlap and relates zero-length intervals as given by z. Unlike
+// CheckRelatedByOverlap, the returned function does not depend on the order of
+// its arguments.
+func CheckRelatedByOverlapZeroLength(z ZeroLength) func(a, b Relatable) bool {
+	return func(a, b Relatable) bool {
+		if a.Chrom() != b.Chrom() {
+			return false
+		}
+		if b.Start() == b.End() {
+			a, b = b, a
+		}
+		if a.Start() != a.End() {
+			return b.Start() < a.End() && a.Start() < b.End()
+		}
+		// a is zero-length.
+		p := a.Start()
+		switch z {
+		case ZeroLengthContained:
+			return b.Start() < p && p < b.End()
+		case ZeroLengthAdjacent:
+			return b.Start() <= p && p <= b.End()
+		}
+		return false
+	}
+}
+
+// strand returns the strand of r or '.' if it is not known.
+func strand(r Relatable) byte {
+	if s, ok := r.(Stranded); ok {
+		return s.Strand()
+	}
+	return '.'
+}
+
+// CheckRelatedByOverlapSameStrand returns true if Relatables overlap and are on
+// the same strand (as with bedtools -s). Relatables that do not implement
+// Stranded or have an unknown strand are not related.
+func CheckRelatedByOverlapSameStrand(a Relatable, b Relatable) bool {
+	if b.Start() >= a.End() || !SameChrom(a.Chrom(), b.Chrom()) {
+		return false
+	}
+	s := strand(a)
+	return s != '.' && s == strand(b)
+}
+
+// CheckRelatedByOverlapOppositeStrand returns true if Relatables overlap and are
+// on opposite strands (as with bedtools -S). Relatables that do not implement
+// Stranded or have an unknown strand are not related.
+func CheckRelatedByOverlapOppositeStrand(a Relatable, b Relatable) bool {
+	if b.Start() >= a.End() || !SameChrom(a.Chrom(), b.Chrom()) {
+		return false
+	}
+	sa, sb := strand(a), strand(b)
+	return sa != '.' && sb != '.' && sa != sb
+}
+
 // CheckKNN relates an interval to its k-nearest neighbors.
 // The reporting function will have to do some filtering since this is only
 // guaranteed to associate *at least* k neighbors, but it could be returning extra.
+// It only considers downstream intervals; see NearestNeighbors for a search in
+// both directions.
 func CheckKNN(a Relatable, b Relatable) bool {
 	// the first n checked would be the n_closest, but need to consider ties
 	// the report function can decide what to do with them.
@@ -90,125 +219,338 @@ func CheckKNN(a Relatable, b Relatable) bool {
 	return true
 }
 
-// filter rewrites the input-slice to remove nils.
-func filter(s []Relatable, nils int) []Relatable {
-	j := 0
-	if len(s) != nils {
-
-		for _, v := range s {
-			if v != nil {
-				s[j] = v
-				j++
-			}
-		}
-	}
-	for k := j; k < len(s); k++ {
-		s[k] = nil
-	}
-	return s[:j]
-}
-
 type irelate struct {
 	checkRelated func(a, b Relatable) bool
-	// relativeTo indicates which stream is the query stream. A value of -1 means
-	// all vs all. A value of -2 reports overlaps even within the same stream.
-	relativeTo int
-	less       func(a, b Relatable) bool
+	// sources indicates which streams are query streams and which pairs of
+	// streams are related (see RelationMatrix).
+	sources *RelationMatrix
+	less    func(a, b Relatable) bool
 	// cache holds the set of Relatables we must test for overlap. A Relatable
-	// is ejected from the cache when it is not related to the interval that's
-	// about to be added.
-	cache []Relatable
+	// is ejected from the cache when it ends before the interval that's about
+	// to be added and is not related to it.
+	cache sweepCache
+	// chrom is the chromosome of the last interval added to the cache.
+	chrom string
+	// rel holds the cached intervals related to the interval that's about to be
+	// added so they can be added to it in order.
+	rel []*entry
 	// an item eject from the cache gets put on the sendQ if it's from the query
 	// stream.
 	sendQ *relatableQueue
 	// mergeStream creates a single (sorted) stream of all incoming intervals.
-	mergeStream RelatableIterator
-	//merger RelatableChannel
-	nils int
+	mergeStream *merger
+	// nq is the number of query intervals in the cache. Once it is 0 and the
+	// query stream is exhausted, nothing else can be related to a query.
+	nq int
+	// reach extends the end of each cached interval when deciding whether it
+	// can be evicted (e.g. for Window).
+	reach uint32
+	// mode is ReportAll unless only the first relation of each query is needed.
+	mode Mode
+	// ctx is checked for each interval; once it is done, the streams are closed
+	// and Next() returns ctx.Err().
+	ctx  context.Context
+	done <-chan struct{}
 }
 
 // IRelate provides the basis for flexible overlap/proximity/k-nearest neighbor
 // testing. IRelate receives merged, ordered Relatables via stream and takes
 // function that checks if they are related (see CheckRelatedByOverlap).
 // It is guaranteed that !Less(b, a) is true (we can't guarantee that Less(a, b)
-// is true since they may have the same start). Once checkRelated returns false,
+// is true since they may have the same start). Ties in less are broken as in
+// TotalOrder so the output is always in the same order. Once checkRelated returns false
+// for a `b` that is on another chromosome or that starts at or after the end of `a`,
 // it is assumed that no other `b` Relatables could possibly be related to `a`
-// and so `a` is sent to the returnQ.
+// and so `a` is sent to the returnQ. This means that checkRelated may return
+// false for other reasons (e.g. strand) while the intervals overlap. While
+// checkRelated returns true, `a` stays in the sweep even after it has ended.
 // streams are a variable number of iterators that send intervals.
+// When relativeTo >= 0, the other streams are closed as soon as no query interval
+// remains that could be related to a later interval. See IRelateMatrix for more
+// than one query stream.
+// If a stream is not sorted or returns an error other than io.EOF, Next() on the
+// returned iterator returns an *ErrUnsorted or *ErrSource respectively.
 func IRelate(checkRelated func(a, b Relatable) bool,
 	relativeTo int,
 	less func(a, b Relatable) bool,
 	streams ...RelatableIterator) RelatableIterator {
+	return IRelateContext(context.Background(), checkRelated, relativeTo, less, streams...)
+}
 
-	mergeStream := newMerger(less, relativeTo, streams...)
+// IRelateContext is the same as IRelate except that once ctx is done, all of the
+// streams are closed and Next() returns ctx.Err().
+func IRelateContext(ctx context.Context, checkRelated func(a, b Relatable) bool,
+	relativeTo int,
+	less func(a, b Relatable) bool,
+	streams ...RelatableIterator) RelatableIterator {
 
-	ir := &irelate{checkRelated: checkRelated, relativeTo: relativeTo,
+	return IRelateMode(ctx, ReportAll, checkRelated, relativeTo, less, streams...)
+}
+
+// IRelateMatrix is the same as IRelateMode with the query sources and the
+// pairs of sources that are related given by m rather than by relativeTo.
+// A source that is not a query is closed as soon as no query interval remains
+// that could be related to a later interval.
+func IRelateMatrix(ctx context.Context, mode Mode, m *RelationMatrix, checkRelated func(a, b Relatable) bool,
+	less func(a, b Relatable) bool,
+	streams ...RelatableIterator) RelatableIterator {
+	return newIRelate(ctx, mode, 0, m, checkRelated, less, streams...)
+}
+
+// newIRelate is IRelateMatrix with intervals kept in the sweep until they end
+// more than reach bases before the current position.
+func newIRelate(ctx context.Context, mode Mode, reach uint32, m *RelationMatrix, checkRelated func(a, b Relatable) bool,
+	less func(a, b Relatable) bool,
+	streams ...RelatableIterator) *irelate {
+
+	less = TotalOrder(less, nil)
+	mergeStream := newMerger(ctx, less, m, streams...)
+
+	ir := &irelate{checkRelated: checkRelated, sources: m,
 		mergeStream: mergeStream,
-		cache:       make([]Relatable, 0, 1024), sendQ: &relatableQueue{make([]Relatable, 0, 1024), less},
-		less: less}
+		sendQ:       &relatableQueue{make([]Relatable, 0, 1024), less},
+		less:        less, reach: reach, mode: mode, ctx: ctx, done: ctx.Done()}
 	return ir
 }
 
-func (ir *irelate) Close() error {
-	return nil
+// IRelateMode is the same as IRelateContext except that it sends only the query
+// intervals selected by mode. With ReportUnrelated or ReportFirst, an interval
+// stops being tested once it is known to be related to something. When
+// relativeTo >= 0, such a query is removed from the sweep immediately; when it
+// is < 0, it remains as a database interval for the others.
+func IRelateMode(ctx context.Context, mode Mode, checkRelated func(a, b Relatable) bool,
+	relativeTo int,
+	less func(a, b Relatable) bool,
+	streams ...RelatableIterator) RelatableIterator {
+	return IRelateMatrix(ctx, mode, relativeToMatrix(relativeTo, len(streams)), checkRelated, less, streams...)
 }
 
-func (ir *irelate) Next() (Relatable, error) {
+func (ir *irelate) isQuery(r Relatable) bool {
+	return ir.sources.IsQuery(r.Source())
+}
 
-	for {
-		interval, err := ir.mergeStream.Next()
-		if err == io.EOF {
-			break
+func (ir *irelate) isDB(r Relatable) bool {
+	return ir.sources.IsDB(r.Source())
+}
+
+// decided is true if r is known to be related to something. A query is only
+// kept in the cache after that when it is also a database interval.
+func (ir *irelate) decided(r Relatable) bool {
+	return ir.isDB(r) && len(r.Related()) > 0
+}
+
+// send is true if a query interval leaving the cache should be sent.
+func (ir *irelate) send(r Relatable) bool {
+	switch ir.mode {
+	case ReportUnrelated:
+		return !ir.decided(r)
+	case ReportFirst:
+		return ir.decided(r)
+	}
+	return true
+}
+
+// related records that c is related to interval.
+func (ir *irelate) related(c *entry, interval Relatable, hit *bool) {
+	if ir.mode != ReportAll {
+		ir.relateFirst(c, interval, hit)
+		return
+	}
+	if ir.sources.Relates(c.Source(), interval.Source()) {
+		addRelated(c.Relatable, interval)
+	}
+	if ir.sources.Relates(interval.Source(), c.Source()) {
+		ir.rel = append(ir.rel, c)
+	}
+}
+
+// relateFirst relates c and interval when only the first relation of each is
+// needed. hit is set once interval is decided.
+func (ir *irelate) relateFirst(c *entry, interval Relatable, hit *bool) {
+	if ir.sources.Relates(c.Source(), interval.Source()) && !ir.decided(c) {
+		if !ir.isDB(c) {
+			// c is not needed as a database interval so it leaves the cache.
+			if ir.mode == ReportFirst {
+				addRelated(c.Relatable, interval)
+				heap.Push(ir.sendQ, c.Relatable)
+			}
+			ir.cache.remove(c)
+			ir.nq--
+		} else {
+			addRelated(c.Relatable, interval)
+		}
+	}
+	if ir.sources.Relates(interval.Source(), c.Source()) && !*hit {
+		*hit = true
+		if ir.mode == ReportFirst || ir.isDB(interval) {
+			addRelated(interval, c.Relatable)
 		}
-		// check the interval against everything in the cache.
-		for i, c := range ir.cache {
-			if c == nil {
+	}
+}
+
+// evict removes c from the cache. If it's a query interval, it is pushed onto
+// the sendQ.
+func (ir *irelate) evict(c *entry) {
+	if ir.isQuery(c) {
+		if ir.send(c) {
+			heap.Push(ir.sendQ, c.Relatable)
+		}
+		ir.nq--
+	}
+	ir.cache.remove(c)
+}
+
+// relatable is true if intervals from src can be related to c.
+func (ir *irelate) relatable(c *entry, src uint32) bool {
+	return ir.sources.Relates(c.Source(), src) || ir.sources.Relates(src, c.Source())
+}
+
+// skip is true if c need not be tested against interval because interval is
+// decided and c can not be related to it. c stays in the cache until it is
+// checked against the next interval.
+func (ir *irelate) skip(c *entry, interval Relatable, hit bool) bool {
+	return hit && !ir.isDB(interval) && !ir.isQuery(c)
+}
+
+// add checks interval against the cache and then adds it to the cache.
+func (ir *irelate) add(interval Relatable) {
+	// only intervals that end before interval can be evicted. Those (and
+	// those that were kept because they were related to the last interval)
+	// are tested whether or not they can be related to interval.
+	if !SameChrom(interval.Chrom(), ir.chrom) {
+		ir.cache.expire(0, 0, true)
+		ir.chrom = interval.Chrom()
+	} else {
+		ir.cache.expire(interval.Start(), ir.reach, false)
+	}
+	// hit is set when interval is decided (only used when mode != ReportAll).
+	hit := false
+	ir.rel = ir.rel[:0]
+	l := ir.cache.lingering
+	j := 0
+	for _, c := range l {
+		if c.dead {
+			continue
+		}
+		if !ir.skip(c, interval, hit) {
+			if !ir.checkRelated(c.Relatable, interval) {
+				ir.evict(c)
 				continue
 			}
-			if ir.checkRelated(c, interval) {
-				relate(c, interval, ir.relativeTo)
-			} else {
-				// if it's not related, we remove it from the cache
-				// if it's a query interval, we push it onto the sendQ.
-				if ir.relativeTo < 0 || int(c.Source()) == ir.relativeTo {
-					heap.Push(ir.sendQ, c)
-				}
-				ir.cache[i] = nil
-				ir.nils++
+			ir.related(c, interval, &hit)
+			if c.dead {
+				continue
 			}
 		}
+		l[j] = c
+		j++
+	}
+	for k := j; k < len(l); k++ {
+		l[k] = nil
+	}
+	ir.cache.lingering = l[:j]
 
-		// only do this when we have a lot of nils as it's expensive to create a new slice.
-		// nils are spaces that we've removed from the cache.
-		if ir.nils < 2 {
-			ir.cache = append(ir.cache, interval)
+	// the rest can only change by being related to interval.
+	src := interval.Source()
+	for _, c := range ir.cache.spanning {
+		if c.dead || !ir.relatable(c, src) || ir.skip(c, interval, hit) {
+			continue
+		}
+		if ir.checkRelated(c.Relatable, interval) {
+			ir.related(c, interval, &hit)
+		}
+	}
+	for _, l := range ir.cache.live {
+		if len(l) == 0 || !ir.relatable(l[0], src) {
 			continue
 		}
-		// remove nils from the cache (must do this before sending)
-		ir.cache, ir.nils = filter(ir.cache, ir.nils), 0
-		var o Relatable
-		if len(ir.sendQ.rels) > 0 {
-			o = ir.sendQ.rels[0]
+		for _, c := range l {
+			if c.expired || c.dead {
+				continue
+			}
+			if ir.skip(c, interval, hit) {
+				// the same is true of all intervals from this source.
+				break
+			}
+			if ir.checkRelated(c.Relatable, interval) {
+				ir.related(c, interval, &hit)
+			}
+		}
+	}
+	if len(ir.rel) > 1 {
+		sort.Sort(bySeq(ir.rel))
+	}
+	for i, c := range ir.rel {
+		addRelated(interval, c.Relatable)
+		ir.rel[i] = nil
+	}
+
+	if ir.isQuery(interval) {
+		if hit && !ir.isDB(interval) {
+			// a decided query is not a database interval so it is not cached.
+			if ir.mode == ReportFirst {
+				heap.Push(ir.sendQ, interval)
+			}
+			return
+		}
+		ir.nq++
+	}
+	ir.cache.add(interval, ir.isQuery(interval))
+}
+
+// ready is true if the first interval in the sendQ can be sent because
+// nothing in the cache is before it.
+func (ir *irelate) ready() bool {
+	if len(ir.sendQ.rels) == 0 {
+		return false
+	}
+	f := ir.cache.first(ir.less)
+	return f == nil || ir.less(ir.sendQ.rels[0], f)
+}
+
+// Close closes any of the streams that have not been exhausted.
+func (ir *irelate) Close() error {
+	return ir.mergeStream.Close()
+}
+
+func (ir *irelate) Next() (Relatable, error) {
+
+	for {
+		if ir.ready() {
+			return heap.Pop(ir.sendQ).(Relatable), nil
+		}
+		select {
+		case <-ir.done:
+			ir.Close()
+			return nil, ir.ctx.Err()
+		default:
+		}
+		if ir.nq == 0 && ir.mergeStream.queryDone() {
+			ir.mergeStream.Close()
+			break
+		}
+		interval, err := ir.mergeStream.Next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		ir.add(interval)
 		// if the first thing in the sendQ is less than the first thing in the cache
 		// then we can send Pop the lowest thing off the sendQ.
 		// otherwise, we continue to read from the stream.
-		if o != nil && (len(ir.cache) == 0 || ir.less(o, ir.cache[0])) {
-			ir.cache = append(ir.cache, interval)
+		if ir.ready() {
 			return heap.Pop(ir.sendQ).(Relatable), nil
 		}
-		ir.cache = append(ir.cache, interval)
 	}
 	// stream is done so we empty the cache by pushing onto the sendQ
-	if len(ir.cache) > 0 {
-		ir.cache, ir.nils = filter(ir.cache, ir.nils), 0
-		for _, c := range ir.cache {
-			if ir.relativeTo < 0 || int(c.Source()) == ir.relativeTo {
-				heap.Push(ir.sendQ, c)
-			}
+	ir.cache.drain(func(c Relatable) {
+		if ir.isQuery(c) && ir.send(c) {
+			heap.Push(ir.sendQ, c)
 		}
-		ir.cache = ir.cache[:0]
-	}
+	})
+	ir.nq = 0
 	// ... then we clear the sendQ
 	if len(ir.sendQ.rels) > 0 {
 		return heap.Pop(ir.sendQ).(Relatable), nil
@@ -217,82 +559,132 @@ func (ir *irelate) Next() (Relatable, error) {
 }
 
 type merger struct {
-	less       func(a, b Relatable) bool
-	relativeTo int
-	streams    []RelatableIterator
-	q          relatableQueue
-	seen       map[string]struct{}
-	j          int
-	lastChrom  string
-	verbose    bool
+	less    func(a, b Relatable) bool
+	sources *RelationMatrix
+	streams []RelatableIterator
+	closed  []bool
+	q       relatableQueue
+	seen    map[string]struct{}
+	// live is the number of query streams with an interval in q.
+	live      int
+	lastChrom string
+	// obs, if not nil, is sent the records read from each source (counted in
+	// records) and the chromosome switches.
+	obs     Observer
+	records []int
+	// err is returned from all calls to Next() after it is set.
+	err error
 }
 
-func newMerger(less func(a, b Relatable) bool, relativeTo int, streams ...RelatableIterator) *merger {
+func newMerger(ctx context.Context, less func(a, b Relatable) bool, sources *RelationMatrix, streams ...RelatableIterator) *merger {
 	q := relatableQueue{make([]Relatable, 0, len(streams)), less}
-	verbose := os.Getenv("IRELATE_VERBOSE") == "TRUE"
+	m := &merger{less: less, sources: sources, streams: streams, closed: make([]bool, len(streams)), q: q, seen: make(map[string]struct{}), lastChrom: "",
+		obs: observerFor(ctx), records: make([]int, len(streams))}
 
 	for i, stream := range streams {
 		interval, err := stream.Next()
-		if err != nil && err != io.EOF {
-			panic(err)
+		if err != nil && err != io.EOF && m.err == nil {
+			m.err = &ErrSource{Source: uint32(i), Err: err}
 		}
 		if interval != nil {
+			m.records[i]++
 			interval.SetSource(uint32(i))
-			heap.Push(&q, interval)
+			heap.Push(&m.q, interval)
+			if sources.IsQuery(uint32(i)) {
+				m.live++
+			}
 		}
 		if err == io.EOF {
-			stream.Close()
+			m.closeStream(i)
 		}
 	}
-	m := &merger{less: less, relativeTo: relativeTo, streams: streams, q: q, seen: make(map[string]struct{}), j: -1000, lastChrom: "", verbose: verbose}
-
 	return m
 }
 
+func (m *merger) closeStream(i int) error {
+	if m.closed[i] {
+		return nil
+	}
+	m.closed[i] = true
+	return m.streams[i].Close()
+}
+
+// queryDone is true when every interval from the query streams has been sent.
+func (m *merger) queryDone() bool {
+	return m.live == 0
+}
+
+// flushRecords sends the number of records read from each source since the
+// last call to m.obs.
+func (m *merger) flushRecords() {
+	if m.obs == nil {
+		return
+	}
+	for i, n := range m.records {
+		if n > 0 {
+			m.obs.Records(i, n)
+			m.records[i] = 0
+		}
+	}
+}
+
+// Close closes any streams that have not already been closed.
 func (m *merger) Close() error {
-	return nil
+	m.flushRecords()
+	var err error
+	for i := range m.streams {
+		if e := m.closeStream(i); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (m *merger) Next() (Relatable, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if len(m.q.rels) == 0 {
+		m.flushRecords()
 		return nil, io.EOF
 	}
 	interval := heap.Pop(&m.q).(Relatable)
 	source := interval.Source()
 	if !SameChrom(interval.Chrom(), m.lastChrom) {
-		if m.verbose && m.lastChrom != "" {
-			log.Printf("on chromosome: %s\n", m.lastChrom)
+		if m.obs != nil {
+			m.flushRecords()
+			m.obs.Chrom(m.lastChrom, interval.Chrom())
 		}
-		m.lastChrom = StripChr(interval.Chrom())
-		if _, ok := m.seen[m.lastChrom]; ok {
-			log.Println("warning: chromosomes must be in different order between files or the chromosome sort order is not as expected.")
-			log.Printf("warning: overlaps will likely be missed after this chrom: %s from source: %d\n", m.lastChrom, interval.Source())
+		m.lastChrom = interval.Chrom()
+		chrom := StripChr(m.lastChrom)
+		if _, ok := m.seen[chrom]; ok {
+			// chromosomes are in a different order between files or the chromosome
+			// sort order is not as expected. overlaps would be missed after this.
+			m.err = &ErrChromOrder{Source: int(source), Chrom: interval.Chrom()}
+			return interval, nil
 		}
-		m.seen[m.lastChrom] = struct{}{}
+		m.seen[chrom] = struct{}{}
 	}
 	// pull the next interval from the same source.
+	// errors are returned on the next call so that interval is not lost.
 	next_interval, err := m.streams[source].Next()
 	if err == nil {
+		m.records[source]++
 		if next_interval.Start() < interval.Start() {
 			if SameChrom(next_interval.Chrom(), interval.Chrom()) {
-				panic(fmt.Sprintf("intervals out of order within file: starts at: %d and %d from source: %d", interval.Start(), next_interval.Start(), source))
+				m.err = &ErrUnsorted{Source: source, Chrom: interval.Chrom(), Prev: interval.Start(), Cur: next_interval.Start()}
+				return interval, nil
 			}
 		}
 		next_interval.SetSource(source)
 		heap.Push(&m.q, next_interval)
-		m.j--
-		if m.j == 0 {
-			return nil, io.EOF
-		}
-	} else {
-		if int(source) == m.relativeTo {
-			// we pull in 200K more records and then stop. to make sure we get anything that might
-			// relate to last query
-			m.j = 200000
-		}
+	} else if m.sources.IsQuery(source) {
+		m.live--
 	}
 	if err == io.EOF {
-		m.streams[source].Close()
+		m.closeStream(int(source))
+	} else if err != nil {
+		m.err = &ErrSource{Source: source, Err: err}
 	}
 	return interval, nil
 }
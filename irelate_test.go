@@ -0,0 +1,610 @@
+package irelate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+)
+
+func iv(chrom string, start, end uint32) Relatable {
+	return parsers.NewInterval(chrom, start, end, nil, 0, nil)
+}
+
+func ivs(rels ...Relatable) RelatableIterator {
+	return sliceToIterator(rels)
+}
+
+// errIt returns err after sending its intervals.
+type errIt struct {
+	RelatableIterator
+	err error
+}
+
+func (e *errIt) Next() (Relatable, error) {
+	v, err := e.RelatableIterator.Next()
+	if err == io.EOF {
+		return nil, e.err
+	}
+	return v, err
+}
+
+func TestIRelateUnsorted(t *testing.T) {
+	a := ivs(iv("chr1", 10, 20), iv("chr1", 30, 40))
+	b := ivs(iv("chr1", 15, 25), iv("chr1", 5, 8))
+
+	it := IRelate(CheckRelatedByOverlap, 0, Less, a, b)
+	var err error
+	for {
+		if _, err = it.Next(); err != nil {
+			break
+		}
+	}
+	u, ok := err.(*ErrUnsorted)
+	if !ok {
+		t.Fatalf("expected *ErrUnsorted, got %v", err)
+	}
+	if u.Source != 1 || u.Chrom != "chr1" || u.Prev != 15 || u.Cur != 5 {
+		t.Errorf("unexpected error: %+v", u)
+	}
+	if _, err2 := it.Next(); err2 != err {
+		t.Errorf("expected error to be repeated, got %v", err2)
+	}
+}
+
+func TestIRelateSourceError(t *testing.T) {
+	bad := errors.New("bad line")
+	a := ivs(iv("chr1", 10, 20), iv("chr1", 30, 40))
+	b := &errIt{ivs(iv("chr1", 15, 25)), bad}
+
+	it := IRelate(CheckRelatedByOverlap, 0, Less, a, b)
+	var err error
+	for {
+		if _, err = it.Next(); err != nil {
+			break
+		}
+	}
+	s, ok := err.(*ErrSource)
+	if !ok {
+		t.Fatalf("expected *ErrSource, got %v", err)
+	}
+	if s.Source != 1 || s.Err != bad {
+		t.Errorf("unexpected error: %+v", s)
+	}
+
+	// error on the first call to Next() of a stream.
+	it = IRelate(CheckRelatedByOverlap, 0, Less, ivs(iv("chr1", 10, 20)), &errIt{ivs(), bad})
+	if _, err = it.Next(); err == nil || err.(*ErrSource).Source != 1 {
+		t.Errorf("expected *ErrSource from source 1, got %v", err)
+	}
+}
+
+func randomIntervals(rng *rand.Rand, chroms []string, n int, maxLen uint32) []Relatable {
+	// chroms must be in sorted order.
+	rels := make([]Relatable, 0, n)
+	for _, chrom := range chroms {
+		c := make([]Relatable, n)
+		for i := range c {
+			s := uint32(rng.Intn(100000))
+			c[i] = iv(chrom, s, s+1+uint32(rng.Intn(int(maxLen))))
+		}
+		sort.Sort(islice(c))
+		rels = append(rels, c...)
+	}
+	return rels
+}
+
+// bruteForce returns the number of intervals in dbs that overlap each query.
+func bruteForce(query []Relatable, dbs ...[]Relatable) map[string]int {
+	counts := make(map[string]int, len(query))
+	for _, q := range query {
+		k := fmt.Sprintf("%s:%d-%d", q.Chrom(), q.Start(), q.End())
+		counts[k] = 0
+		for _, db := range dbs {
+			for _, d := range db {
+				if OverlapsPosition(q, d) {
+					counts[k]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// readIt counts the intervals read from a stream.
+type readIt struct {
+	RelatableIterator
+	n int
+}
+
+func (r *readIt) Next() (Relatable, error) {
+	v, err := r.RelatableIterator.Next()
+	if err == nil {
+		r.n++
+	}
+	return v, err
+}
+
+func TestIRelateBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	for trial := 0; trial < 20; trial++ {
+		// sparse queries, dense databases with some very long intervals.
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 1+rng.Intn(50), 2000)
+		db1 := randomIntervals(rng, []string{"chr1", "chr2", "chr3"}, 3000, 300)
+		db2 := randomIntervals(rng, []string{"chr1", "chr2"}, 50, 50000)
+		expected := bruteForce(query, db1, db2)
+
+		qcopy := make([]Relatable, len(query))
+		for i, q := range query {
+			qcopy[i] = iv(q.Chrom(), q.Start(), q.End())
+		}
+		r1 := &readIt{RelatableIterator: ivs(db1...)}
+		it := IRelate(CheckRelatedByOverlap, 0, Less, ivs(qcopy...), r1, ivs(db2...))
+		i := 0
+		for {
+			r, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i > 0 && Less(r, query[i-1]) {
+				t.Fatalf("trial %d: query %d out of order: %s:%d-%d", trial, i, r.Chrom(), r.Start(), r.End())
+			}
+			// queries at the same position can not be told apart so they are found by position.
+			k := fmt.Sprintf("%s:%d-%d", r.Chrom(), r.Start(), r.End())
+			if n, ok := expected[k]; !ok || len(r.Related()) != n {
+				t.Errorf("trial %d: expected %d relations for %s, got %d", trial, n, k, len(r.Related()))
+			}
+			i++
+		}
+		if i != len(query) {
+			t.Errorf("trial %d: expected %d queries, got %d", trial, len(query), i)
+		}
+		// chr3 is never needed.
+		if r1.n >= len(db1) {
+			t.Errorf("trial %d: expected database stream to stop early", trial)
+		}
+	}
+}
+
+func stranded(chrom string, start, end uint32, strand string) Relatable {
+	i, err := parsers.IntervalFromBedLine([]byte(fmt.Sprintf("%s\t%d\t%d\tname\t0\t%s", chrom, start, end, strand)))
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+func TestStrandRelations(t *testing.T) {
+	query := func() RelatableIterator {
+		return ivs(stranded("chr1", 10, 100, "+"), stranded("chr1", 200, 300, "-"), stranded("chr1", 400, 500, "."))
+	}
+	db := []Relatable{stranded("chr1", 20, 30, "-"), stranded("chr1", 50, 60, "+"), stranded("chr1", 250, 260, "-"),
+		stranded("chr1", 270, 280, "+"), stranded("chr1", 450, 460, "+")}
+	for _, tc := range []struct {
+		check    func(a, b Relatable) bool
+		expected []int
+	}{
+		{CheckRelatedByOverlap, []int{2, 2, 1}},
+		{CheckRelatedByOverlapSameStrand, []int{1, 1, 0}},
+		{CheckRelatedByOverlapOppositeStrand, []int{1, 1, 0}},
+	} {
+		var got []int
+		it := IRelate(tc.check, 0, Less, query(), ivs(db...))
+		for r, err := it.Next(); err == nil; r, err = it.Next() {
+			got = append(got, len(r.Related()))
+		}
+		ch, errf := PIRelateWithOptions(context.Background(), query(), &PIRelateOptions{ChunkSize: 1, MaxGap: 10, CheckRelated: tc.check}, newSliceQueryable(db))
+		var pgot []int
+		for r := range ch {
+			pgot = append(pgot, len(r.Related()))
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.expected) || fmt.Sprint(pgot) != fmt.Sprint(tc.expected) {
+			t.Errorf("expected %v, got %v from IRelate and %v from PIRelate", tc.expected, got, pgot)
+		}
+	}
+}
+
+func TestRelatedEdges(t *testing.T) {
+	it := Window(100, 100, false).IRelate(0, Less, ivs(iv("chr1", 100, 200)),
+		ivs(iv("chr1", 0, 40), iv("chr1", 50, 150), iv("chr1", 250, 400)))
+	r, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := r.(EdgeRelatable).RelatedEdges()
+	exp := []Edge{{Overlap: 0, Distance: -60, Side: Before},
+		{Overlap: 50, Distance: 0, Side: Overlapping},
+		{Overlap: 0, Distance: 50, Side: After}}
+	if len(edges) != len(exp) || len(r.Related()) != len(exp) {
+		t.Fatalf("expected %d edges, got %d", len(exp), len(edges))
+	}
+	for i, e := range edges {
+		if e.Related != r.Related()[i] {
+			t.Errorf("expected edge %d to match Related()", i)
+		}
+		e.Related = nil
+		if e != exp[i] {
+			t.Errorf("edge %d: expected %+v, got %+v", i, exp[i], e)
+		}
+	}
+}
+
+func TestIRelateMode(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for trial := 0; trial < 10; trial++ {
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 200, 500)
+		db := randomIntervals(rng, []string{"chr1", "chr2"}, 100, 300)
+		counts := bruteForce(query, db)
+		key := func(r Relatable) string { return fmt.Sprintf("%s:%d-%d", r.Chrom(), r.Start(), r.End()) }
+		copies := func(rels []Relatable) []Relatable {
+			c := make([]Relatable, len(rels))
+			for i, r := range rels {
+				c[i] = iv(r.Chrom(), r.Start(), r.End())
+			}
+			return c
+		}
+		for _, mode := range []Mode{ReportUnrelated, ReportFirst} {
+			want := 0
+			for _, q := range query {
+				if (counts[key(q)] == 0) == (mode == ReportUnrelated) {
+					want++
+				}
+			}
+			it := IRelateMode(context.Background(), mode, CheckRelatedByOverlap, 0, Less, ivs(copies(query)...), ivs(copies(db)...))
+			ch, errf := PIRelateWithOptions(context.Background(), ivs(copies(query)...), &PIRelateOptions{ChunkSize: 20, MaxGap: 1000, Mode: mode}, newSliceQueryable(db))
+			pit := &chanIt{ch}
+			for name, it := range map[string]RelatableIterator{"IRelate": it, "PIRelate": pit} {
+				n := 0
+				var last Relatable
+				for r, err := it.Next(); err == nil; r, err = it.Next() {
+					if last != nil && Less(r, last) {
+						t.Fatalf("%s mode %d: out of order", name, mode)
+					}
+					c := counts[key(r)]
+					if (mode == ReportUnrelated && (c != 0 || len(r.Related()) != 0)) || (mode == ReportFirst && (c == 0 || len(r.Related()) != 1)) {
+						t.Errorf("%s mode %d: unexpected %s with %d relations", name, mode, key(r), len(r.Related()))
+					}
+					last = r
+					n++
+				}
+				if n != want {
+					t.Errorf("%s mode %d: expected %d intervals, got %d", name, mode, want, n)
+				}
+			}
+			if err := errf(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestIRelateModeAll(t *testing.T) {
+	// with relativeTo < 0, a decided interval still relates to later intervals.
+	rels := func() []RelatableIterator {
+		a := iv("chr1", 0, 100)
+		b := iv("chr1", 50, 60)
+		c := iv("chr1", 90, 200)
+		d := iv("chr1", 300, 400)
+		return []RelatableIterator{ivs(a, d), ivs(b, c)}
+	}
+	var got []string
+	it := IRelateMode(context.Background(), ReportUnrelated, CheckRelatedByOverlap, -1, Less, rels()...)
+	for r, err := it.Next(); err == nil; r, err = it.Next() {
+		got = append(got, fmt.Sprintf("%d-%d", r.Start(), r.End()))
+	}
+	if fmt.Sprint(got) != "[300-400]" {
+		t.Errorf("expected only 300-400, got %v", got)
+	}
+	got = got[:0]
+	it = IRelateMode(context.Background(), ReportFirst, CheckRelatedByOverlap, -1, Less, rels()...)
+	for r, err := it.Next(); err == nil; r, err = it.Next() {
+		got = append(got, fmt.Sprintf("%d-%d:%d", r.Start(), r.End(), len(r.Related())))
+	}
+	if fmt.Sprint(got) != "[0-100:1 50-60:1 90-200:1]" {
+		t.Errorf("unexpected intervals: %v", got)
+	}
+}
+
+// chanIt reads a RelatableChannel as a RelatableIterator.
+type chanIt struct {
+	ch RelatableChannel
+}
+
+func (c *chanIt) Next() (Relatable, error) {
+	r, ok := <-c.ch
+	if !ok {
+		return nil, io.EOF
+	}
+	return r, nil
+}
+
+func (c *chanIt) Close() error { return nil }
+
+func TestIRelateMatrix(t *testing.T) {
+	// tumor (0) and normal (1) are both related to annotations (2) but not to
+	// each other.
+	m := NewRelationMatrix().Relate(0, 2).Relate(1, 2)
+	tumor := ivs(iv("chr1", 10, 20), iv("chr1", 100, 110))
+	normal := ivs(iv("chr1", 15, 25))
+	ann := ivs(iv("chr1", 0, 50), iv("chr1", 500, 600))
+	it := IRelateMatrix(context.Background(), ReportAll, m, CheckRelatedByOverlap, Less, tumor, normal, ann)
+	var got []string
+	for r, err := it.Next(); err == nil; r, err = it.Next() {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d", r.Source(), r.Start(), r.End(), len(r.Related())))
+		for _, o := range r.Related() {
+			if o.Source() != 2 {
+				t.Errorf("unexpected relation to source %d", o.Source())
+			}
+		}
+	}
+	if fmt.Sprint(got) != "[0:10-20:1 1:15-25:1 0:100-110:0]" {
+		t.Errorf("unexpected intervals: %v", got)
+	}
+}
+
+func TestIRelateCustomCheckEvicts(t *testing.T) {
+	// sameStrand relates intervals on the same strand up to 50 bases apart.
+	sameStrand := func(a, b Relatable) bool {
+		return SameChrom(a.Chrom(), b.Chrom()) && b.Start() < a.End()+50 &&
+			a.(Stranded).Strand() == b.(Stranded).Strand()
+	}
+	for _, tc := range []struct {
+		strand   string
+		expected int
+	}{
+		// the query is related to the first database interval so it stays for the second.
+		{"+", 2},
+		// the query has ended and is not related to the first so it is sent without
+		// being tested against the second.
+		{"-", 0},
+	} {
+		query := ivs(stranded("chr1", 0, 10, "+"))
+		db := ivs(stranded("chr1", 20, 30, tc.strand), stranded("chr1", 40, 45, "+"))
+		r, err := IRelate(sameStrand, 0, Less, query, db).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Related()) != tc.expected {
+			t.Errorf("%s: expected %d relations, got %d", tc.strand, tc.expected, len(r.Related()))
+		}
+	}
+}
+
+func TestIRelateCustomCheck(t *testing.T) {
+	// within relates intervals up to 50 bases apart without a reach, so intervals
+	// must stay in the cache for as long as they are related.
+	within := func(a, b Relatable) bool {
+		return b.Start() < a.End()+50 && SameChrom(a.Chrom(), b.Chrom())
+	}
+	near := func(a, b Relatable) bool {
+		return SameChrom(a.Chrom(), b.Chrom()) && b.Start() < a.End()+50 && a.Start() < b.End()+50
+	}
+	rng := rand.New(rand.NewSource(11))
+	for trial := 0; trial < 10; trial++ {
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 100, 200)
+		db := randomIntervals(rng, []string{"chr1", "chr2"}, 2000, 100)
+		it := IRelate(within, 0, Less, ivs(query...), ivs(db...))
+		n := 0
+		for r, err := it.Next(); err == nil; r, err = it.Next() {
+			exp := 0
+			for _, d := range db {
+				if near(r, d) {
+					exp++
+				}
+			}
+			if len(r.Related()) != exp {
+				t.Errorf("trial %d: expected %d relations for %d-%d, got %d", trial, exp, r.Start(), r.End(), len(r.Related()))
+			}
+			for i := 1; i < len(r.Related()); i++ {
+				if Less(r.Related()[i], r.Related()[i-1]) {
+					t.Errorf("trial %d: related intervals out of order", trial)
+				}
+			}
+			n++
+		}
+		if n != len(query) {
+			t.Errorf("trial %d: expected %d queries, got %d", trial, len(query), n)
+		}
+	}
+}
+
+func TestIRelateSpanning(t *testing.T) {
+	// a chromosome-wide database interval does not hold back the queries.
+	db := append([]Relatable{iv("chr1", 0, 10000000)}, spaced("chr1", 10000, 100, 50)...)
+	sort.Sort(islice(db))
+	r1 := &readIt{RelatableIterator: ivs(db...)}
+	it := IRelate(CheckRelatedByOverlap, 0, Less, ivs(spaced("chr1", 100, 1000, 10)...), r1)
+	r, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Related()) != 2 || r1.n > 10 {
+		t.Errorf("expected 2 relations after reading a few intervals, got %d after reading %d", len(r.Related()), r1.n)
+	}
+
+	// long queries and database intervals give the same relations as without them.
+	rng := rand.New(rand.NewSource(13))
+	for trial := 0; trial < 10; trial++ {
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 50, 1000)
+		query = append(query, iv("chr1", 5000, 5000+2*spanLength), iv("chr2", 0, 3*spanLength))
+		db := randomIntervals(rng, []string{"chr1", "chr2"}, 500, 200)
+		db = append(db, iv("chr1", 100, 100+spanLength+1), iv("chr2", 20000, 30000+spanLength))
+		sort.Sort(islice(query))
+		sort.Sort(islice(db))
+		sort.SliceStable(query, func(i, j int) bool { return query[i].Chrom() < query[j].Chrom() })
+		sort.SliceStable(db, func(i, j int) bool { return db[i].Chrom() < db[j].Chrom() })
+		expected := bruteForce(query, db)
+		it := IRelate(CheckRelatedByOverlap, 0, Less, ivs(query...), ivs(db...))
+		var last Relatable
+		n := 0
+		for r, err := it.Next(); err == nil; r, err = it.Next() {
+			if last != nil && Less(r, last) {
+				t.Fatalf("trial %d: out of order", trial)
+			}
+			k := fmt.Sprintf("%s:%d-%d", r.Chrom(), r.Start(), r.End())
+			if len(r.Related()) != expected[k] {
+				t.Errorf("trial %d: expected %d relations for %s, got %d", trial, expected[k], k, len(r.Related()))
+			}
+			last = r
+			n++
+		}
+		if n != len(query) {
+			t.Errorf("trial %d: expected %d queries, got %d", trial, len(query), n)
+		}
+	}
+}
+
+func TestTotalOrder(t *testing.T) {
+	named := func(chrom string, start, end uint32, name string) Relatable {
+		return parsers.NewInterval(chrom, start, end, [][]byte{[]byte(name)}, 0, nil)
+	}
+	byName := func(a, b Relatable) bool {
+		return string(a.(*parsers.Interval).Fields[0]) < string(b.(*parsers.Interval).Fields[0])
+	}
+	a := ivs(named("chr1", 10, 20, "c"), named("chr1", 10, 20, "a"), named("chr1", 10, 30, "b"))
+	b := ivs(named("chr1", 10, 20, "d"), named("chr1", 10, 20, "e"))
+	it := IRelate(CheckRelatedByOverlap, -1, TotalOrder(Less, byName), a, b)
+	var got []string
+	for r, err := it.Next(); err == nil; r, err = it.Next() {
+		got = append(got, fmt.Sprintf("%d:%s", r.Source(), r.(*parsers.Interval).Fields[0]))
+	}
+	if fmt.Sprint(got) != "[0:a 0:c 1:d 1:e 0:b]" {
+		t.Errorf("unexpected order: %v", got)
+	}
+}
+
+func TestPIRelateDeterministic(t *testing.T) {
+	// many intervals at the same positions.
+	rng := rand.New(rand.NewSource(5))
+	query := make([]Relatable, 3000)
+	for i := range query {
+		s := uint32(rng.Intn(200)) * 50
+		query[i] = parsers.NewInterval("chr1", s, s+uint32(rng.Intn(3))*10+10, [][]byte{[]byte(fmt.Sprint(i))}, 0, nil)
+	}
+	sort.Stable(islice(query))
+	db := randomIntervals(rng, []string{"chr1"}, 2000, 50)
+	run := func(procs int) string {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		copies := make([]Relatable, len(query))
+		for i, q := range query {
+			copies[i] = parsers.NewInterval(q.Chrom(), q.Start(), q.End(), q.(*parsers.Interval).Fields, 0, nil)
+		}
+		ch, errf := PIRelateWithOptions(context.Background(), ivs(copies...), &PIRelateOptions{ChunkSize: 50, MaxGap: 20}, newSliceQueryable(db))
+		var buf []byte
+		for r := range ch {
+			buf = fmt.Appendf(buf, "%s:%d-%d", r.(*parsers.Interval).Fields[0], r.Start(), r.End())
+			for _, o := range r.Related() {
+				buf = fmt.Appendf(buf, " %d-%d", o.Start(), o.End())
+			}
+			buf = append(buf, '\n')
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+	exp := run(1)
+	for _, procs := range []int{2, 8, 1} {
+		if got := run(procs); got != exp {
+			t.Errorf("output with GOMAXPROCS=%d differs from GOMAXPROCS=1", procs)
+		}
+	}
+}
+
+func TestZeroLength(t *testing.T) {
+	named := func(name string, start, end uint32) Relatable {
+		return parsers.NewInterval("chr1", start, end, [][]byte{[]byte(name)}, 0, nil)
+	}
+	name := func(r Relatable) string { return string(r.(*parsers.Interval).Fields[0]) }
+	run := func(check func(a, b Relatable) bool) string {
+		query := []Relatable{named("q0", 10, 10), named("q1", 20, 30), named("q2", 40, 40)}
+		db := []Relatable{named("d0", 5, 10), named("d2", 8, 12), named("d1", 10, 10),
+			named("d4", 25, 25), named("d3", 30, 30), named("d5", 40, 50)}
+		it := IRelate(check, 0, Less, ivs(query...), ivs(db...))
+		var out []string
+		for {
+			r, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rel []string
+			for _, b := range r.Related() {
+				rel = append(rel, name(b))
+			}
+			sort.Strings(rel)
+			out = append(out, fmt.Sprintf("%s:%v", name(r), rel))
+		}
+		return fmt.Sprint(out)
+	}
+	for _, c := range []struct {
+		name  string
+		check func(a, b Relatable) bool
+		want  string
+	}{
+		{"none", CheckRelatedByOverlapZeroLength(ZeroLengthNone), "[q0:[] q1:[] q2:[]]"},
+		{"contained", CheckRelatedByOverlapZeroLength(ZeroLengthContained), "[q0:[d2] q1:[d4] q2:[]]"},
+		{"default", CheckRelatedByOverlap, "[q0:[d2] q1:[d4] q2:[]]"},
+		{"adjacent", CheckRelatedByOverlapZeroLength(ZeroLengthAdjacent), "[q0:[d0 d1 d2] q1:[d3 d4] q2:[d5]]"},
+	} {
+		if got := run(c.check); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+
+	// the sweep must find the same relations as testing every pair.
+	rng := rand.New(rand.NewSource(19))
+	random := func(n int) []Relatable {
+		rs := make([]Relatable, n)
+		for i := range rs {
+			s := uint32(rng.Intn(500))
+			e := s
+			if rng.Intn(2) == 0 {
+				e += uint32(1 + rng.Intn(20))
+			}
+			rs[i] = named(fmt.Sprint(i), s, e)
+		}
+		sort.SliceStable(rs, func(i, j int) bool { return Less(rs[i], rs[j]) })
+		return rs
+	}
+	for _, z := range []ZeroLength{ZeroLengthNone, ZeroLengthContained, ZeroLengthAdjacent} {
+		check := CheckRelatedByOverlapZeroLength(z)
+		query, db := random(100), random(300)
+		want := make(map[string]int)
+		for _, q := range query {
+			for _, d := range db {
+				if check(q, d) {
+					want[name(q)]++
+				}
+			}
+		}
+		it := IRelate(check, 0, Less, ivs(query...), ivs(db...))
+		for {
+			r, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(r.Related()); n != want[name(r)] {
+				t.Errorf("zero length %d: expected %d relations for %d-%d, got %d", z, want[name(r)], r.Start(), r.End(), n)
+			}
+		}
+	}
+}
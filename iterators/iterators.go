@@ -0,0 +1,322 @@
+// Package iterators provides adapters for interfaces.RelatableIterator.
+// Each adapter closes the iterators it wraps when it is closed, and closes an
+// iterator as soon as it is no longer needed (e.g. by Take).
+package iterators
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+type filter struct {
+	RelatableIterator
+	keep func(Relatable) bool
+}
+
+// Filter sends only the intervals from it for which keep returns true.
+func Filter(it RelatableIterator, keep func(Relatable) bool) RelatableIterator {
+	return &filter{RelatableIterator: it, keep: keep}
+}
+
+func (f *filter) Next() (Relatable, error) {
+	for {
+		r, err := f.RelatableIterator.Next()
+		if err != nil || f.keep(r) {
+			return r, err
+		}
+	}
+}
+
+type mapper struct {
+	RelatableIterator
+	fn func(Relatable) Relatable
+}
+
+// Map sends fn(r) for each interval r from it. fn may change the coordinates
+// (e.g. to add flanks) but if the output is sent to IRelate, it must remain sorted.
+func Map(it RelatableIterator, fn func(Relatable) Relatable) RelatableIterator {
+	return &mapper{RelatableIterator: it, fn: fn}
+}
+
+func (m *mapper) Next() (Relatable, error) {
+	r, err := m.RelatableIterator.Next()
+	if err != nil {
+		return r, err
+	}
+	return m.fn(r), nil
+}
+
+type take struct {
+	RelatableIterator
+	n      int
+	closed bool
+}
+
+// Take sends the first n intervals from it and then closes it.
+func Take(it RelatableIterator, n int) RelatableIterator {
+	return &take{RelatableIterator: it, n: n}
+}
+
+func (t *take) Next() (Relatable, error) {
+	if t.n <= 0 {
+		t.Close()
+		return nil, io.EOF
+	}
+	t.n--
+	return t.RelatableIterator.Next()
+}
+
+func (t *take) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	return t.RelatableIterator.Close()
+}
+
+type concat struct {
+	its []RelatableIterator
+}
+
+// Concat sends the intervals from each of its in turn. Each is closed once it
+// is exhausted.
+func Concat(its ...RelatableIterator) RelatableIterator {
+	return &concat{its: its}
+}
+
+func (c *concat) Next() (Relatable, error) {
+	for len(c.its) > 0 {
+		r, err := c.its[0].Next()
+		if err != io.EOF {
+			return r, err
+		}
+		if err = c.its[0].Close(); err != nil {
+			return nil, err
+		}
+		c.its = c.its[1:]
+	}
+	return nil, io.EOF
+}
+
+// Close closes the iterators that have not been exhausted.
+func (c *concat) Close() error {
+	var err error
+	for _, it := range c.its {
+		if e := it.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.its = nil
+	return err
+}
+
+type dedup struct {
+	RelatableIterator
+	same func(a, b Relatable) bool
+	last Relatable
+}
+
+// Dedup drops each interval for which same(last, r) is true where last is the
+// last interval sent. If same is nil, intervals at the same position as the
+// last are dropped (see SamePosition), so it should be used on sorted input.
+func Dedup(it RelatableIterator, same func(a, b Relatable) bool) RelatableIterator {
+	if same == nil {
+		same = func(a, b Relatable) bool { return SamePosition(a, b) }
+	}
+	return &dedup{RelatableIterator: it, same: same}
+}
+
+func (d *dedup) Next() (Relatable, error) {
+	for {
+		r, err := d.RelatableIterator.Next()
+		if err != nil {
+			return r, err
+		}
+		if d.last == nil || !d.same(d.last, r) {
+			d.last = r
+			return r, nil
+		}
+	}
+}
+
+// regions holds the merged regions on each chromosome sorted by start.
+type regions map[string][]IPosition
+
+type region struct {
+	start, end uint32
+}
+
+func (r region) Chrom() string { return "" }
+func (r region) Start() uint32 { return r.start }
+func (r region) End() uint32   { return r.end }
+
+// RegionFilter sends only the intervals from it that overlap at least one of
+// regs. Chromosomes are matched with or without a "chr" prefix.
+func RegionFilter(it RelatableIterator, regs ...IPosition) RelatableIterator {
+	byChrom := make(regions)
+	for _, r := range regs {
+		c := StripChr(r.Chrom())
+		byChrom[c] = append(byChrom[c], r)
+	}
+	for c, rs := range byChrom {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Start() < rs[j].Start() })
+		merged := make([]IPosition, 0, len(rs))
+		cur := region{rs[0].Start(), rs[0].End()}
+		for _, r := range rs[1:] {
+			if r.Start() > cur.end {
+				merged = append(merged, cur)
+				cur = region{r.Start(), r.End()}
+			} else if r.End() > cur.end {
+				cur.end = r.End()
+			}
+		}
+		byChrom[c] = append(merged, cur)
+	}
+	return Filter(it, byChrom.overlaps)
+}
+
+func (rs regions) overlaps(r Relatable) bool {
+	regs := rs[StripChr(r.Chrom())]
+	// the first region that ends after r starts.
+	i := sort.Search(len(regs), func(i int) bool { return regs[i].End() > r.Start() })
+	return i < len(regs) && regs[i].Start() < r.End()
+}
+
+// tee holds the intervals that one side of a Tee has not yet read.
+type tee struct {
+	mu     sync.Mutex
+	it     RelatableIterator
+	bufs   [2][]Relatable
+	closed [2]bool
+	err    error
+}
+
+type teeSide struct {
+	t *tee
+	i int
+}
+
+// Tee returns 2 iterators that each send all of the intervals from it. They
+// may be read at different rates (and from different goroutines); intervals
+// that one has read and the other has not are kept in memory. it is closed
+// once both are closed.
+func Tee(it RelatableIterator) (RelatableIterator, RelatableIterator) {
+	t := &tee{it: it}
+	return &teeSide{t, 0}, &teeSide{t, 1}
+}
+
+func (s *teeSide) Next() (Relatable, error) {
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed[s.i] {
+		return nil, io.EOF
+	}
+	if b := t.bufs[s.i]; len(b) > 0 {
+		r := b[0]
+		b[0] = nil
+		t.bufs[s.i] = b[1:]
+		return r, nil
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	r, err := t.it.Next()
+	if err != nil {
+		t.err = err
+		return nil, err
+	}
+	if o := 1 - s.i; !t.closed[o] {
+		t.bufs[o] = append(t.bufs[o], r)
+	}
+	return r, nil
+}
+
+func (s *teeSide) Close() error {
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed[s.i] {
+		return nil
+	}
+	t.closed[s.i] = true
+	t.bufs[s.i] = nil
+	if t.closed[1-s.i] {
+		return t.it.Close()
+	}
+	return nil
+}
+
+type fromChannel struct {
+	ch     RelatableChannel
+	closed bool
+}
+
+// FromChannel returns an iterator that sends the intervals from ch until it
+// is closed. Close reads (and drops) the rest of ch so that the sender is not
+// blocked.
+func FromChannel(ch RelatableChannel) RelatableIterator {
+	return &fromChannel{ch: ch}
+}
+
+func (f *fromChannel) Next() (Relatable, error) {
+	if f.closed {
+		return nil, io.EOF
+	}
+	r, ok := <-f.ch
+	if !ok {
+		f.closed = true
+		return nil, io.EOF
+	}
+	return r, nil
+}
+
+func (f *fromChannel) Close() error {
+	if !f.closed {
+		f.closed = true
+		go func(ch RelatableChannel) {
+			for range ch {
+			}
+		}(f.ch)
+	}
+	return nil
+}
+
+// ToChannel sends the intervals from it on the returned channel, which is
+// closed after the last interval. it is closed once it is exhausted, when it
+// returns an error or when ctx is done. The returned function must be called
+// after the channel is closed; it reports the error from it (other than
+// io.EOF), the error from Close or ctx.Err() if the output was cut short by ctx.
+func ToChannel(ctx context.Context, it RelatableIterator) (RelatableChannel, func() error) {
+	ch := make(RelatableChannel, 64)
+	var err error
+	go func() {
+		defer close(ch)
+		defer func() {
+			if e := it.Close(); e != nil && err == nil {
+				err = e
+			}
+		}()
+		for {
+			r, e := it.Next()
+			if e == io.EOF {
+				return
+			}
+			if e != nil {
+				err = e
+				return
+			}
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return ch, func() error { return err }
+}
@@ -0,0 +1,254 @@
+package iterators_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/iterators"
+	"github.com/brentp/irelate/parsers"
+)
+
+// sliceIt is a RelatableIterator over a slice that records whether it was closed.
+type sliceIt struct {
+	rels   []Relatable
+	err    error
+	closed int
+}
+
+func (s *sliceIt) Next() (Relatable, error) {
+	if len(s.rels) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	r := s.rels[0]
+	s.rels = s.rels[1:]
+	return r, nil
+}
+
+func (s *sliceIt) Close() error {
+	s.closed++
+	return nil
+}
+
+func iv(chrom string, start, end uint32) Relatable {
+	return parsers.NewInterval(chrom, start, end, nil, 0, nil)
+}
+
+func newIt(coords ...uint32) *sliceIt {
+	s := &sliceIt{}
+	for i := 0; i < len(coords); i += 2 {
+		s.rels = append(s.rels, iv("chr1", coords[i], coords[i+1]))
+	}
+	return s
+}
+
+func collect(t *testing.T, it RelatableIterator) string {
+	t.Helper()
+	var out []string
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, fmt.Sprintf("%s:%d-%d", r.Chrom(), r.Start(), r.End()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestFilterMap(t *testing.T) {
+	src := newIt(1, 5, 10, 50, 20, 22)
+	it := iterators.Map(iterators.Filter(src, func(r Relatable) bool { return r.End()-r.Start() < 10 }),
+		func(r Relatable) Relatable { return iv(r.Chrom(), r.Start()+1, r.End()+1) })
+	if got := collect(t, it); got != "chr1:2-6 chr1:21-23" {
+		t.Errorf("got %q", got)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+}
+
+func TestTake(t *testing.T) {
+	src := newIt(1, 5, 10, 50, 20, 22)
+	it := iterators.Take(src, 2)
+	if got := collect(t, it); got != "chr1:1-5 chr1:10-50" {
+		t.Errorf("got %q", got)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	a, b, c := newIt(1, 5), newIt(), newIt(10, 20, 30, 40)
+	if got := collect(t, iterators.Concat(a, b, c)); got != "chr1:1-5 chr1:10-20 chr1:30-40" {
+		t.Errorf("got %q", got)
+	}
+	for i, s := range []*sliceIt{a, b, c} {
+		if s.closed != 1 {
+			t.Errorf("stream %d: expected to be closed once, got %d", i, s.closed)
+		}
+	}
+
+	a, b = newIt(1, 5, 6, 7), newIt(10, 20)
+	it := iterators.Concat(a, b)
+	it.Next()
+	it.Close()
+	if a.closed != 1 || b.closed != 1 {
+		t.Errorf("expected early Close to close all streams: %d %d", a.closed, b.closed)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	src := newIt(1, 5, 1, 5, 1, 6, 1, 6, 1, 6, 8, 9)
+	if got := collect(t, iterators.Dedup(src, nil)); got != "chr1:1-5 chr1:1-6 chr1:8-9" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRegionFilter(t *testing.T) {
+	src := &sliceIt{rels: []Relatable{
+		iv("chr1", 1, 5), iv("chr1", 5, 10), iv("chr1", 40, 45),
+		iv("chr1", 99, 100), iv("chr2", 1, 5), iv("chr3", 1, 5)}}
+	it := iterators.RegionFilter(src, iv("1", 8, 20), iv("chr1", 15, 50), iv("chr1", 100, 200), iv("chr2", 0, 2))
+	if got := collect(t, it); got != "chr1:5-10 chr1:40-45 chr2:1-5" {
+		t.Errorf("got %q", got)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+}
+
+func TestTee(t *testing.T) {
+	src := newIt(1, 5, 10, 50, 20, 22)
+	a, b := iterators.Tee(src)
+	var wg sync.WaitGroup
+	var ga string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ga = collect(t, a)
+	}()
+	gb := collect(t, b)
+	wg.Wait()
+	want := "chr1:1-5 chr1:10-50 chr1:20-22"
+	if ga != want || gb != want {
+		t.Errorf("got %q and %q", ga, gb)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+
+	src = newIt(1, 5, 10, 50)
+	a, b = iterators.Tee(src)
+	a.Close()
+	if src.closed != 0 {
+		t.Errorf("expected the source to stay open until both sides are closed")
+	}
+	if got := collect(t, b); got != "chr1:1-5 chr1:10-50" {
+		t.Errorf("got %q", got)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	src := newIt(1, 5, 10, 50, 20, 22)
+	ch, errf := iterators.ToChannel(context.Background(), src)
+	if got := collect(t, iterators.FromChannel(ch)); got != "chr1:1-5 chr1:10-50 chr1:20-22" {
+		t.Errorf("got %q", got)
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+
+	// closing the reader early must not block the sender.
+	src = newIt(make([]uint32, 1000)...)
+	ch, errf = iterators.ToChannel(context.Background(), src)
+	it := iterators.FromChannel(ch)
+	it.Next()
+	it.Close()
+	for range ch {
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src = newIt(make([]uint32, 1000)...)
+	ch, errf = iterators.ToChannel(ctx, src)
+	<-ch
+	cancel()
+	for range ch {
+	}
+	if err := errf(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+
+	bad := errors.New("bad")
+	src = newIt(1, 5)
+	src.err = bad
+	ch, errf = iterators.ToChannel(context.Background(), src)
+	for range ch {
+	}
+	if err := errf(); err != bad {
+		t.Errorf("expected the error from the stream, got %v", err)
+	}
+}
+
+func TestSeq(t *testing.T) {
+	src := newIt(1, 5, 10, 50, 20, 22)
+	var got []uint32
+	for r, err := range iterators.Seq(src) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r.Start())
+		if len(got) == 2 {
+			break
+		}
+	}
+	if fmt.Sprint(got) != "[1 10]" {
+		t.Errorf("got %v", got)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected break to close the source, got %d", src.closed)
+	}
+
+	bad := errors.New("bad")
+	src = newIt(1, 5)
+	src.err = bad
+	var errs []error
+	for _, err := range iterators.Seq(src) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 || errs[0] != nil || errs[1] != bad {
+		t.Errorf("expected the error to be sent once after the interval, got %v", errs)
+	}
+	if src.closed != 1 {
+		t.Errorf("expected the source to be closed once, got %d", src.closed)
+	}
+}
@@ -0,0 +1,52 @@
+package iterators
+
+import (
+	"io"
+	"iter"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// Seq returns the intervals from it for use with range:
+//
+//	for r, err := range iterators.Seq(it) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// An error other than io.EOF is sent once, with a nil interval, and ends the
+// loop. it is closed when the loop ends, including when it is stopped early
+// by break or return. The returned sequence can only be used once.
+func Seq(it RelatableIterator) iter.Seq2[Relatable, error] {
+	return func(yield func(Relatable, error) bool) {
+		defer it.Close()
+		for {
+			r, err := it.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+// QuerySeq returns the intervals from q in region for use with range. q is
+// queried when the loop starts; an error from Query is sent as from Seq.
+func QuerySeq(q Queryable, region IPosition) iter.Seq2[Relatable, error] {
+	return func(yield func(Relatable, error) bool) {
+		it, err := q.Query(region)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		Seq(it)(yield)
+	}
+}
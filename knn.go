@@ -0,0 +1,376 @@
+package irelate
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// Ties determines which neighbors NearestNeighbors keeps when more than one is
+// at the distance of the k'th nearest.
+type Ties int
+
+const (
+	// TiesAll keeps all of the tied neighbors.
+	TiesAll Ties = iota
+	// TiesFirst keeps the tied neighbors that appear first in the sort order.
+	TiesFirst
+	// TiesLast keeps the tied neighbors that appear last in the sort order.
+	TiesLast
+)
+
+// Direction restricts the neighbors that NearestNeighbors considers.
+// Upstream neighbors end before the query starts; downstream neighbors start
+// after it ends. Both are in reference coordinates.
+type Direction int
+
+const (
+	// Both considers upstream and downstream neighbors.
+	Both Direction = iota
+	// Upstream ignores downstream neighbors.
+	Upstream
+	// Downstream ignores upstream neighbors.
+	Downstream
+)
+
+// KNNOptions configures NearestNeighbors.
+type KNNOptions struct {
+	Ties Ties
+	// IgnoreOverlaps ignores neighbors that overlap the query.
+	IgnoreOverlaps bool
+	Direction      Direction
+	// MaxDistance ignores neighbors that are further than this from the query.
+	// A value of 0 means no limit. It must be set for PIRelate, which pads the
+	// region of each chunk by MaxDistance.
+	MaxDistance uint32
+}
+
+// KNN relates each query interval to its k nearest neighbors from the other
+// sources. Unlike CheckKNN, it considers neighbors both upstream and downstream
+// of the query. Related() holds the neighbors from nearest to furthest;
+// overlapping neighbors have a distance of 0.
+type KNN struct {
+	k    int
+	opts KNNOptions
+}
+
+// NearestNeighbors returns a KNN that finds the k nearest neighbors.
+func NearestNeighbors(k int, opts KNNOptions) *KNN {
+	if k < 1 {
+		k = 1
+	}
+	return &KNN{k: k, opts: opts}
+}
+
+// IRelate streams the query intervals with their nearest neighbors added. The
+// arguments are as for IRelate. If relativeTo is < 0, each interval is a query
+// and its neighbors are from the other sources.
+func (n *KNN) IRelate(relativeTo int, less func(a, b Relatable) bool, streams ...RelatableIterator) RelatableIterator {
+	return n.IRelateContext(context.Background(), relativeTo, less, streams...)
+}
+
+// IRelateContext is the same as IRelate but stops when ctx is done (see IRelateContext).
+func (n *KNN) IRelateContext(ctx context.Context, relativeTo int, less func(a, b Relatable) bool, streams ...RelatableIterator) RelatableIterator {
+	if relativeTo == SelfRelations {
+		relativeTo = -1
+	}
+	sources := relativeToMatrix(relativeTo, len(streams))
+	return &knn{KNN: n, sources: sources, mergeStream: newMerger(ctx, TotalOrder(less, nil), sources, streams...),
+		ended: make([][]neighbor, len(streams)), ctx: ctx, done: ctx.Done()}
+}
+
+// PIRelate runs the nearest neighbor search in parallel over chunks of the
+// query (see PIRelateWithOptions). The database regions are padded by
+// MaxDistance so that the neighbors of intervals at the edge of a chunk are
+// found. Without a MaxDistance, each chunk would have to query whole
+// chromosomes, so the channel is closed at once and the error function
+// returns an error; use IRelate instead.
+func (n *KNN) PIRelate(ctx context.Context, qstream RelatableIterator, opts *PIRelateOptions, dbs ...Queryable) (RelatableChannel, func() error) {
+	if n.opts.MaxDistance == 0 {
+		qstream.Close()
+		ch := make(RelatableChannel)
+		close(ch)
+		err := errors.New("irelate: KNN.PIRelate requires KNNOptions.MaxDistance")
+		return ch, func() error { return err }
+	}
+	o := *opts
+	o.sweep = func(ctx context.Context, streams ...RelatableIterator) RelatableIterator {
+		return n.IRelateContext(ctx, 0, o.order(), streams...)
+	}
+	o.padLeft = min(int(n.opts.MaxDistance), wholeChrom)
+	o.padRight = o.padLeft
+	return PIRelateWithOptions(ctx, qstream, &o, dbs...)
+}
+
+// neighbor is an interval in the sweep or a candidate neighbor of a query.
+type neighbor struct {
+	Relatable
+	dist uint32
+	// seq is the position in the sort order and breaks ties.
+	seq int
+}
+
+// endQueue is a min-heap of neighbors ordered by End().
+type endQueue []neighbor
+
+func (q endQueue) Len() int            { return len(q) }
+func (q endQueue) Less(i, j int) bool  { return q[i].End() < q[j].End() }
+func (q endQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *endQueue) Push(i interface{}) { *q = append(*q, i.(neighbor)) }
+func (q *endQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	old[len(old)-1] = neighbor{}
+	*q = old[:len(old)-1]
+	return n
+}
+
+// byEndDesc sorts neighbors with the largest End() first.
+type byEndDesc []neighbor
+
+func (b byEndDesc) Len() int           { return len(b) }
+func (b byEndDesc) Less(i, j int) bool { return b[i].End() > b[j].End() }
+func (b byEndDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// byDist sorts neighbors by distance and then by sort order.
+type byDist []neighbor
+
+func (b byDist) Len() int { return len(b) }
+func (b byDist) Less(i, j int) bool {
+	return b[i].dist < b[j].dist || (b[i].dist == b[j].dist && b[i].seq < b[j].seq)
+}
+func (b byDist) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+type knnQuery struct {
+	Relatable
+	cands []neighbor
+	// kth is the distance to the k'th nearest candidate; it is only valid
+	// when sorted is true.
+	kth      uint32
+	sorted   bool
+	complete bool
+}
+
+type knn struct {
+	*KNN
+	sources     *RelationMatrix
+	mergeStream *merger
+	chrom       string
+	seq         int
+	// active holds database intervals that end after the current position.
+	active endQueue
+	// ended holds the database intervals, by source, that end at or before
+	// the current position and are the nearest upstream of it.
+	ended [][]neighbor
+	// pending holds queries in order until their downstream neighbors are known.
+	pending []*knnQuery
+	ctx     context.Context
+	done    <-chan struct{}
+}
+
+func (k *knn) isQuery(src uint32) bool {
+	return k.sources.IsQuery(src)
+}
+
+func (k *knn) isDB(src uint32) bool {
+	return k.sources.IsDB(src)
+}
+
+// distance returns the distance from q to d and the side of q that d is on.
+// Overlapping intervals have a distance of 0 and a Direction of Both.
+func distance(q, d IPosition) (uint32, Direction) {
+	if d.End() <= q.Start() {
+		return q.Start() - d.End(), Upstream
+	}
+	if d.Start() >= q.End() {
+		return d.Start() - q.End(), Downstream
+	}
+	return 0, Both
+}
+
+func (k *knn) add(q *knnQuery, d neighbor) {
+	if q.complete || !k.sources.Relates(q.Source(), d.Source()) {
+		return
+	}
+	dist, dir := distance(q, d)
+	if dir == Both && k.opts.IgnoreOverlaps {
+		return
+	}
+	if dir != Both && k.opts.Direction != Both && dir != k.opts.Direction {
+		return
+	}
+	if k.opts.MaxDistance > 0 && dist > k.opts.MaxDistance {
+		return
+	}
+	d.dist = dist
+	q.cands = append(q.cands, d)
+	q.sorted = false
+}
+
+// checkComplete marks q as complete if no interval starting at or after pos can
+// be one of its k nearest neighbors.
+func (k *knn) checkComplete(q *knnQuery, pos uint32) {
+	if q.complete {
+		return
+	}
+	if k.opts.Direction == Upstream && (k.opts.IgnoreOverlaps || pos >= q.End()) {
+		// only overlaps can follow the query.
+		q.complete = true
+		return
+	}
+	if pos < q.End() {
+		// a later interval could overlap.
+		return
+	}
+	lower := pos - q.End()
+	if k.opts.MaxDistance > 0 && lower > k.opts.MaxDistance {
+		q.complete = true
+		return
+	}
+	if len(q.cands) < k.k {
+		return
+	}
+	if !q.sorted {
+		sort.Sort(byDist(q.cands))
+		q.kth, q.sorted = q.cands[k.k-1].dist, true
+	}
+	if q.kth < lower {
+		q.complete = true
+	}
+}
+
+// finish adds the k nearest neighbors to q, nearest first.
+func (k *knn) finish(q *knnQuery) Relatable {
+	c := q.cands
+	sort.Sort(byDist(c))
+	if len(c) > k.k {
+		kth := c[k.k-1].dist
+		switch k.opts.Ties {
+		case TiesAll:
+			n := k.k
+			for n < len(c) && c[n].dist == kth {
+				n++
+			}
+			c = c[:n]
+		case TiesFirst:
+			c = c[:k.k]
+		case TiesLast:
+			lo, hi := k.k-1, k.k
+			for lo > 0 && c[lo-1].dist == kth {
+				lo--
+			}
+			for hi < len(c) && c[hi].dist == kth {
+				hi++
+			}
+			c = append(c[:lo:lo], c[hi-(k.k-lo):hi]...)
+		}
+	}
+	for _, n := range c {
+		addRelated(q.Relatable, n.Relatable)
+	}
+	q.cands = nil
+	return q.Relatable
+}
+
+// advance moves database intervals that end at or before pos from active to
+// ended, keeping only the nearest (and any ties) for each source.
+func (k *knn) advance(pos uint32) {
+	for len(k.active) > 0 && k.active[0].End() <= pos {
+		d := heap.Pop(&k.active).(neighbor)
+		src := d.Source()
+		e := append(k.ended[src], d)
+		sort.Sort(byEndDesc(e))
+		if len(e) > k.k {
+			kth := e[k.k-1].End()
+			n := k.k
+			for n < len(e) && e[n].End() == kth {
+				n++
+			}
+			for i := n; i < len(e); i++ {
+				e[i] = neighbor{}
+			}
+			e = e[:n]
+		}
+		k.ended[src] = e
+	}
+}
+
+// flush completes all pending queries and clears the sweep for a new chromosome.
+func (k *knn) flush() {
+	for _, q := range k.pending {
+		q.complete = true
+	}
+	k.active = k.active[:0]
+	for i := range k.ended {
+		k.ended[i] = k.ended[i][:0]
+	}
+}
+
+func (k *knn) Next() (Relatable, error) {
+	for {
+		if len(k.pending) > 0 && k.pending[0].complete {
+			q := k.pending[0]
+			k.pending[0] = nil
+			k.pending = k.pending[1:]
+			return k.finish(q), nil
+		}
+		select {
+		case <-k.done:
+			k.Close()
+			return nil, k.ctx.Err()
+		default:
+		}
+		if len(k.pending) == 0 && k.mergeStream.queryDone() {
+			k.Close()
+			return nil, io.EOF
+		}
+		x, err := k.mergeStream.Next()
+		if err == io.EOF {
+			if len(k.pending) == 0 {
+				return nil, io.EOF
+			}
+			k.flush()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !SameChrom(x.Chrom(), k.chrom) {
+			k.flush()
+			k.chrom = x.Chrom()
+		}
+		k.advance(x.Start())
+		k.seq++
+		n := neighbor{Relatable: x, seq: k.seq}
+		db := k.isDB(x.Source())
+		for _, q := range k.pending {
+			if db {
+				k.add(q, n)
+			}
+			k.checkComplete(q, x.Start())
+		}
+		if k.isQuery(x.Source()) {
+			q := &knnQuery{Relatable: x}
+			for _, d := range k.active {
+				k.add(q, d)
+			}
+			for _, e := range k.ended {
+				for _, d := range e {
+					k.add(q, d)
+				}
+			}
+			k.pending = append(k.pending, q)
+		}
+		if db {
+			heap.Push(&k.active, n)
+		}
+	}
+}
+
+func (k *knn) Close() error {
+	return k.mergeStream.Close()
+}
@@ -0,0 +1,154 @@
+package irelate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+func key(r Relatable) string {
+	return fmt.Sprintf("%s:%d-%d", r.Chrom(), r.Start(), r.End())
+}
+
+func copyIntervals(rels []Relatable) []Relatable {
+	out := make([]Relatable, len(rels))
+	for i, r := range rels {
+		out[i] = iv(r.Chrom(), r.Start(), r.End())
+	}
+	return out
+}
+
+// bruteKNN returns the neighbors of q (with all ties) as sorted keys.
+func bruteKNN(q Relatable, k int, opts KNNOptions, dbs ...[]Relatable) []string {
+	var cands []neighbor
+	for _, db := range dbs {
+		for _, d := range db {
+			if !SameChrom(q.Chrom(), d.Chrom()) {
+				continue
+			}
+			dist, dir := distance(q, d)
+			if (dir == Both && opts.IgnoreOverlaps) || (dir != Both && opts.Direction != Both && dir != opts.Direction) {
+				continue
+			}
+			if opts.MaxDistance > 0 && dist > opts.MaxDistance {
+				continue
+			}
+			cands = append(cands, neighbor{Relatable: d, dist: dist})
+		}
+	}
+	sort.Sort(byDist(cands))
+	keys := make([]string, 0, k)
+	for i, c := range cands {
+		if i >= k && c.dist != cands[k-1].dist {
+			break
+		}
+		keys = append(keys, key(c))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func relatedKeys(r Relatable) []string {
+	keys := make([]string, 0, len(r.Related()))
+	for _, o := range r.Related() {
+		keys = append(keys, key(o))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestKNNBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for trial := 0; trial < 40; trial++ {
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 1+rng.Intn(40), 2000)
+		db1 := randomIntervals(rng, []string{"chr1", "chr2", "chr3"}, 1+rng.Intn(300), 300)
+		db2 := randomIntervals(rng, []string{"chr2"}, 20, 5000)
+		k := 1 + rng.Intn(3)
+		opts := KNNOptions{IgnoreOverlaps: rng.Intn(2) == 0, Direction: Direction(rng.Intn(3))}
+		if rng.Intn(2) == 0 {
+			opts.MaxDistance = uint32(rng.Intn(2000))
+		}
+
+		it := NearestNeighbors(k, opts).IRelate(0, Less, ivs(copyIntervals(query)...), ivs(db1...), ivs(db2...))
+		n := 0
+		for {
+			r, err := it.Next()
+			if err != nil {
+				break
+			}
+			exp := bruteKNN(r, k, opts, db1, db2)
+			if got := relatedKeys(r); len(got) != len(exp) || (len(got) > 0 && !reflect.DeepEqual(got, exp)) {
+				t.Fatalf("trial %d k=%d %+v: %s expected %v, got %v", trial, k, opts, key(r), exp, got)
+			}
+			n++
+		}
+		if n != len(query) {
+			t.Fatalf("trial %d: expected %d intervals, got %d", trial, len(query), n)
+		}
+	}
+}
+
+func TestKNNTies(t *testing.T) {
+	db := func() RelatableIterator {
+		return ivs(iv("chr1", 80, 90), iv("chr1", 120, 130), iv("chr1", 130, 140))
+	}
+	for _, tt := range []struct {
+		ties Ties
+		k    int
+		exp  []string
+	}{
+		{TiesAll, 1, []string{"chr1:80-90", "chr1:120-130"}},
+		{TiesFirst, 1, []string{"chr1:80-90"}},
+		{TiesLast, 1, []string{"chr1:120-130"}},
+		{TiesFirst, 2, []string{"chr1:80-90", "chr1:120-130"}},
+		{TiesAll, 3, []string{"chr1:80-90", "chr1:120-130", "chr1:130-140"}},
+	} {
+		it := NearestNeighbors(tt.k, KNNOptions{Ties: tt.ties}).IRelate(0, Less, ivs(iv("chr1", 100, 110)), db())
+		r, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range r.Related() {
+			got = append(got, key(o))
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("ties %d k=%d: expected %v, got %v", tt.ties, tt.k, tt.exp, got)
+		}
+	}
+}
+
+func TestKNNPIRelate(t *testing.T) {
+	dbs := randomIntervals(rand.New(rand.NewSource(3)), []string{"chr1"}, 400, 100)
+	db := newSliceQueryable(dbs)
+	query := spaced("chr1", 500, 200, 20)
+	opts := KNNOptions{MaxDistance: 1000}
+
+	ch, errf := NearestNeighbors(2, opts).PIRelate(context.Background(), ivs(query...), &PIRelateOptions{ChunkSize: 20, MaxGap: 1000}, db)
+	n := 0
+	for r := range ch {
+		if exp, got := bruteKNN(r, 2, opts, dbs), relatedKeys(r); !reflect.DeepEqual(exp, got) {
+			t.Errorf("%s: expected %v, got %v", key(r), exp, got)
+		}
+		n++
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(query) {
+		t.Errorf("expected %d intervals, got %d", len(query), n)
+	}
+
+	ch, errf = NearestNeighbors(2, KNNOptions{}).PIRelate(context.Background(), ivs(query...), &PIRelateOptions{ChunkSize: 20, MaxGap: 1000}, db)
+	for range ch {
+		t.Fatal("expected no intervals without MaxDistance")
+	}
+	if errf() == nil {
+		t.Error("expected an error without MaxDistance")
+	}
+}
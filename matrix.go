@@ -0,0 +1,86 @@
+package irelate
+
+// RelationMatrix determines which sources are query sources and which pairs of
+// sources may be related. It generalizes the relativeTo argument to IRelate so
+// that, for example, tumor and normal VCFs can both be queries against a shared
+// annotation set without being related to each other:
+//
+//	m := NewRelationMatrix()
+//	m.Relate(0, 2) // tumor gets annotations
+//	m.Relate(1, 2) // normal gets annotations
+type RelationMatrix struct {
+	// queries[i] is true if intervals from source i are sent.
+	queries []bool
+	// dbs[i] is true if intervals from source i are related to a query source.
+	dbs []bool
+	// relates[a][b] is true if intervals from b are added to intervals from a.
+	relates [][]bool
+}
+
+// NewRelationMatrix returns a RelationMatrix with no query sources.
+func NewRelationMatrix() *RelationMatrix {
+	return &RelationMatrix{}
+}
+
+// relativeToMatrix returns the RelationMatrix for a relativeTo argument to
+// IRelate with n streams.
+func relativeToMatrix(relativeTo int, n int) *RelationMatrix {
+	m := NewRelationMatrix()
+	for a := 0; a < n; a++ {
+		if relativeTo < 0 || relativeTo == a {
+			// a query is sent even if there are no other sources.
+			m.grow(uint32(a))
+			m.queries[a] = true
+		}
+		for b := 0; b < n; b++ {
+			if relativeTo == SelfRelations || (a != b && (relativeTo == -1 || relativeTo == a)) {
+				m.Relate(uint32(a), uint32(b))
+			}
+		}
+	}
+	return m
+}
+
+func (m *RelationMatrix) grow(n uint32) {
+	for uint32(len(m.relates)) <= n {
+		m.queries = append(m.queries, false)
+		m.dbs = append(m.dbs, false)
+		m.relates = append(m.relates, nil)
+	}
+	for i, r := range m.relates {
+		for uint32(len(r)) < uint32(len(m.relates)) {
+			r = append(r, false)
+		}
+		m.relates[i] = r
+	}
+}
+
+// Relate marks query as a query source and relates the intervals from db to
+// those from query. query and db may be the same source to report relations
+// within it (as with SelfRelations). It returns m so that calls can be chained.
+func (m *RelationMatrix) Relate(query, db uint32) *RelationMatrix {
+	if query > db {
+		m.grow(query)
+	} else {
+		m.grow(db)
+	}
+	m.queries[query] = true
+	m.dbs[db] = true
+	m.relates[query][db] = true
+	return m
+}
+
+// IsQuery is true if the intervals from source are sent.
+func (m *RelationMatrix) IsQuery(source uint32) bool {
+	return source < uint32(len(m.queries)) && m.queries[source]
+}
+
+// IsDB is true if the intervals from source are related to a query source.
+func (m *RelationMatrix) IsDB(source uint32) bool {
+	return source < uint32(len(m.dbs)) && m.dbs[source]
+}
+
+// Relates is true if intervals from db are added to intervals from query.
+func (m *RelationMatrix) Relates(query, db uint32) bool {
+	return query < uint32(len(m.relates)) && db < uint32(len(m.relates)) && m.relates[query][db]
+}
@@ -0,0 +1,167 @@
+package irelate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Observer receives progress and metrics events from PIRelate (see
+// PIRelateOptions.Observer) and from IRelate (see WithObserver). Its methods
+// are called from many goroutines; they must be safe for concurrent use and
+// should return quickly. Embed NopObserver to implement only some of them.
+type Observer interface {
+	// ChunkCreated is called when a chunk of query intervals has been read.
+	ChunkCreated(c ChunkInfo)
+	// ChunkDone is called when the sweep of a chunk is done.
+	ChunkDone(c ChunkInfo, t ChunkTimings)
+	// Records is called with the number of records read from a source; the
+	// query is source 0 in PIRelate. It is called once per chunk in PIRelate
+	// and once per chromosome in IRelate.
+	Records(source int, n int)
+	// Queues is called with the depths of the queues of PIRelate each time a
+	// chunk is created.
+	Queues(q QueueDepths)
+	// Chrom is called when the query (or the sweep in IRelate) moves to the
+	// next chromosome. prev is "" for the first.
+	Chrom(prev, next string)
+}
+
+// ChunkInfo describes a chunk of query intervals in PIRelate.
+type ChunkInfo struct {
+	// ID is the index of the chunk in the query (see Chunk.ID).
+	ID    int
+	Chrom string
+	// Start and End give the region queried from the databases.
+	Start, End int
+	// Queries is the number of query intervals in the chunk.
+	Queries int
+	// Parsed is the number of bases queried from the databases (End - Start)
+	// and Skipped is the number of bases between the region of the last chunk
+	// on the same chromosome and this one, which are not read at all.
+	Parsed, Skipped int
+}
+
+// ChunkTimings gives the time spent on a chunk in PIRelate.
+type ChunkTimings struct {
+	// Query is the time taken to query the databases.
+	Query time.Duration
+	// Wait is the time from when the chunk was created until its sweep started.
+	Wait time.Duration
+	// Sweep is the time spent in the sweep, not counting the time it was
+	// blocked by the chunks (or the output) after it.
+	Sweep time.Duration
+}
+
+// QueueDepths gives the number of items waiting in the queues of PIRelate.
+type QueueDepths struct {
+	// Reading is the number of chunks whose databases are being queried.
+	Reading int
+	// Sweeping is the number of chunks waiting to be sent.
+	Sweeping int
+	// Output is the number of intervals (or chunks with PIRelateChunks) in
+	// the output channel.
+	Output int
+	// InFlight is the cost in flight when MaxInFlight is set.
+	InFlight int64
+}
+
+// NopObserver ignores all events. Embed it in an Observer that only needs some.
+type NopObserver struct{}
+
+func (NopObserver) ChunkCreated(ChunkInfo)            {}
+func (NopObserver) ChunkDone(ChunkInfo, ChunkTimings) {}
+func (NopObserver) Records(int, int)                  {}
+func (NopObserver) Queues(QueueDepths)                {}
+func (NopObserver) Chrom(string, string)              {}
+
+type multiObserver []Observer
+
+// MultiObserver sends each event to all of obs in turn.
+func MultiObserver(obs ...Observer) Observer {
+	return multiObserver(obs)
+}
+
+func (m multiObserver) ChunkCreated(c ChunkInfo) {
+	for _, o := range m {
+		o.ChunkCreated(c)
+	}
+}
+
+func (m multiObserver) ChunkDone(c ChunkInfo, t ChunkTimings) {
+	for _, o := range m {
+		o.ChunkDone(c, t)
+	}
+}
+
+func (m multiObserver) Records(source int, n int) {
+	for _, o := range m {
+		o.Records(source, n)
+	}
+}
+
+func (m multiObserver) Queues(q QueueDepths) {
+	for _, o := range m {
+		o.Queues(q)
+	}
+}
+
+func (m multiObserver) Chrom(prev, next string) {
+	for _, o := range m {
+		o.Chrom(prev, next)
+	}
+}
+
+type observerKey struct{}
+
+// WithObserver returns a copy of ctx that carries o. IRelateContext (and the
+// functions like it that take a context) send their events to it, as does
+// PIRelate if PIRelateOptions.Observer is not set.
+func WithObserver(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, o)
+}
+
+// ObserverFrom returns the Observer carried by ctx or nil.
+func ObserverFrom(ctx context.Context) Observer {
+	o, _ := ctx.Value(observerKey{}).(Observer)
+	return o
+}
+
+// observerFor returns the Observer for ctx or nil if it is a NopObserver. If
+// there is none and IRELATE_VERBOSE is TRUE, events are logged.
+func observerFor(ctx context.Context) Observer {
+	if o := ObserverFrom(ctx); o != nil {
+		if _, ok := o.(NopObserver); ok {
+			return nil
+		}
+		return o
+	}
+	if os.Getenv("IRELATE_VERBOSE") == "TRUE" {
+		return logObserver{}
+	}
+	return nil
+}
+
+// logObserver logs events as IRELATE_VERBOSE did before Observer.
+type logObserver struct{ NopObserver }
+
+func (logObserver) ChunkCreated(c ChunkInfo) {
+	log.Println("intervals in current chunk:", c.Queries, fmt.Sprintf("%s:%d-%d", c.Chrom, c.Start, c.End), "skipped:", c.Skipped)
+}
+
+func (logObserver) Queues(q QueueDepths) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Println("\treceivers:", q.Reading, "tochannels:", q.Sweeping, "intersected:", q.Output)
+	log.Printf("\tmemory use: %dMB , heap in use: %dMB\n", mem.Alloc/uint64(1000*1000),
+		mem.HeapInuse/uint64(1000*1000))
+}
+
+func (logObserver) Chrom(prev, next string) {
+	if prev != "" {
+		log.Printf("on chromosome: %s\n", prev)
+	}
+}
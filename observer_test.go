@@ -0,0 +1,206 @@
+package irelate
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Observer that keeps the events it receives.
+type recorder struct {
+	mu      sync.Mutex
+	created []ChunkInfo
+	done    []ChunkInfo
+	records map[int]int
+	chroms  []string
+	queues  int
+}
+
+func newRecorder() *recorder {
+	return &recorder{records: make(map[int]int)}
+}
+
+func (r *recorder) ChunkCreated(c ChunkInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created = append(r.created, c)
+}
+
+func (r *recorder) ChunkDone(c ChunkInfo, t ChunkTimings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = append(r.done, c)
+}
+
+func (r *recorder) Records(source int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[source] += n
+}
+
+func (r *recorder) Queues(q QueueDepths) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queues++
+}
+
+func (r *recorder) Chrom(prev, next string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chroms = append(r.chroms, prev+">"+next)
+}
+
+func TestPIRelateObserver(t *testing.T) {
+	query := append(spaced("chr1", 1000, 20, 5), spaced("chr2", 1000, 20, 5)...)
+	db := newSliceQueryable(append(spaced("chr1", 2000, 10, 15), spaced("chr2", 2000, 10, 15)...))
+	rec := newRecorder()
+	ch, errf := PIRelateWithOptions(context.Background(), ivs(query...), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000, Observer: rec}, db)
+	for range ch {
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.created) == 0 || len(rec.created) != len(rec.done) || rec.queues != len(rec.created) {
+		t.Fatalf("expected a ChunkDone and Queues for each chunk: %d %d %d", len(rec.created), len(rec.done), rec.queues)
+	}
+	n := 0
+	for i, c := range rec.created {
+		if c.ID != i {
+			t.Errorf("expected chunk %d, got %d", i, c.ID)
+		}
+		if c.Parsed != c.End-c.Start {
+			t.Errorf("expected Parsed to be the size of the region: %+v", c)
+		}
+		n += c.Queries
+	}
+	if n != len(query) || rec.records[0] != len(query) {
+		t.Errorf("expected %d queries, got %d and %d", len(query), n, rec.records[0])
+	}
+	// the queries cover all but the last database intervals of each chromosome.
+	if rec.records[1] < 3990 || rec.records[1] > 4000 {
+		t.Errorf("expected the database intervals to be counted, got %d", rec.records[1])
+	}
+	if strings.Join(rec.chroms, " ") != ">chr1 chr1>chr2" {
+		t.Errorf("unexpected chromosome switches: %v", rec.chroms)
+	}
+}
+
+func TestObserverSkipped(t *testing.T) {
+	query := spaced("chr1", 100, 20, 5)
+	for i := uint32(0); i < 100; i++ {
+		query = append(query, iv("chr1", 100000+i*20, 100000+i*20+5))
+	}
+	rec := newRecorder()
+	ch, errf := PIRelateWithOptions(context.Background(), ivs(query...), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000, Observer: rec},
+		newSliceQueryable(spaced("chr1", 20000, 10, 15)))
+	for range ch {
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.created) != 2 || rec.created[1].Skipped != rec.created[1].Start-rec.created[0].End || rec.created[1].Skipped < 90000 {
+		t.Errorf("expected the gap between the chunks to be skipped: %+v", rec.created)
+	}
+}
+
+func TestIRelateObserver(t *testing.T) {
+	a := append(spaced("chr1", 10, 20, 5), spaced("chr2", 10, 20, 5)...)
+	b := append(spaced("chr1", 30, 10, 15), spaced("chr2", 5, 10, 15)...)
+	rec := newRecorder()
+	it := IRelateContext(WithObserver(context.Background(), rec), CheckRelatedByOverlap, 0, Less, ivs(a...), ivs(b...))
+	for _, err := it.Next(); err == nil; _, err = it.Next() {
+	}
+	it.Close()
+	if strings.Join(rec.chroms, " ") != ">chr1 chr1>chr2" {
+		t.Errorf("unexpected chromosome switches: %v", rec.chroms)
+	}
+	if rec.records[0] != 20 || rec.records[1] != 35 {
+		t.Errorf("expected the records of each source, got %v", rec.records)
+	}
+
+	// the sweeps of PIRelate are not observed.
+	rec = newRecorder()
+	ch, errf := PIRelateWithOptions(WithObserver(context.Background(), rec), ivs(spaced("chr1", 1000, 20, 5)...),
+		&PIRelateOptions{ChunkSize: 100, MaxGap: 1000}, newSliceQueryable(spaced("chr1", 2000, 10, 15)))
+	for range ch {
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.chroms) != 1 || rec.records[0] != 1000 {
+		t.Errorf("expected the events of PIRelate only, got %v %v", rec.chroms, rec.records)
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	e := NewExpvarObserver("irelate_test")
+	ch, errf := PIRelateWithOptions(context.Background(), ivs(spaced("chr1", 1000, 20, 5)...),
+		&PIRelateOptions{ChunkSize: 100, MaxGap: 1000, Observer: MultiObserver(e, NopObserver{})}, newSliceQueryable(spaced("chr1", 2000, 10, 15)))
+	for range ch {
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	m := expvar.Get("irelate_test").(*expvar.Map)
+	if v := m.Get("queries").String(); v != "1000" {
+		t.Errorf("expected 1000 queries, got %s", v)
+	}
+	if v := m.Get("chunks_done").String(); v != m.Get("chunks_created").String() {
+		t.Errorf("expected all chunks to be done: %s", v)
+	}
+	if v := e.records.Get("0").String(); v != "1000" {
+		t.Errorf("expected 1000 records from the query, got %s", v)
+	}
+	if v := m.Get("chrom").String(); v != `"chr1"` {
+		t.Errorf("expected chr1, got %s", v)
+	}
+	if NewExpvarObserver("irelate_test").m != m {
+		t.Error("expected the map to be reused")
+	}
+}
+
+// linearIndex is an Offsetter with 1 byte of file per base.
+type linearIndex struct{ chroms []string }
+
+func (l linearIndex) Offsets(chrom string, start, end int) (int64, int64, bool) {
+	for i, c := range l.chroms {
+		if c == chrom {
+			return int64(i*1000 + start), int64(i*1000 + min(end, 1000)), true
+		}
+	}
+	return 0, 0, false
+}
+
+func (l linearIndex) Size() int64 { return int64(1000 * len(l.chroms)) }
+
+func TestProgress(t *testing.T) {
+	p := NewProgress(linearIndex{[]string{"chr1", "chr2"}})
+	if done, left := p.Estimate(); done != 0 || left != -1 {
+		t.Errorf("expected nothing done, got %v %v", done, left)
+	}
+	p.ChunkDone(ChunkInfo{Chrom: "chr1", Start: 0, End: 500}, ChunkTimings{})
+	if done, left := p.Estimate(); done != 0.25 || left < 0 {
+		t.Errorf("expected 25%% done, got %v %v", done, left)
+	}
+	p.Chrom("chr1", "chr2")
+	p.ChunkDone(ChunkInfo{Chrom: "chr1", Start: 0, End: 100}, ChunkTimings{})
+	if done, _ := p.Estimate(); done != 0.5 {
+		t.Errorf("expected 50%% done, got %v", done)
+	}
+	p.ChunkDone(ChunkInfo{Chrom: "chrX", Start: 0, End: 100}, ChunkTimings{})
+	if !strings.HasPrefix(p.String(), "50.0% done") {
+		t.Errorf("unexpected report: %s", p)
+	}
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	p.Report(ctx, &buf, 5*time.Millisecond)
+	if !strings.Contains(buf.String(), "50.0% done") {
+		t.Errorf("expected the progress to be reported, got %q", buf.String())
+	}
+}
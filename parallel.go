@@ -9,7 +9,7 @@ package irelate
 // more detailed explanations are provided whenever a channel is initialized
 // as channels are our main means of keeping order.
 // For example
-//     tochannels := make(chan chan chan []interfaces.Relatable, 2+nprocs/2)
+//     tochannels := make(chan swept, opts.ChunkQueue) // a swept holds a chan chan batch
 // Seems to have excessive use of channels, but we actually do need this since
 // we have 2 levels of parallelization.
 // One level is by chunk of query intervals.
@@ -30,12 +30,13 @@ package irelate
 // though no obvious candidates have emerged.
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"log"
-	"os"
 	"runtime"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/brentp/irelate/interfaces"
 )
@@ -105,13 +106,7 @@ func (i islice) Len() int {
 }
 
 func (i islice) Less(a, b int) bool {
-	if i[a].Start() < i[b].Start() {
-		return true
-	}
-	if i[a].Start() == i[b].Start() && i[a].End() <= i[b].End() {
-		return true
-	}
-	return false
+	return less(i[a], i[b])
 }
 
 func (is islice) Swap(i, j int) {
@@ -134,26 +129,111 @@ func (p pos) End() uint32 {
 	return uint32(p.end)
 }
 
+// pending is a chunk of query intervals whose databases are being queried.
+type pending struct {
+	streams chan []interfaces.RelatableIterator
+	info    ChunkInfo
+	created time.Time
+	// query is the time taken by makeStreams. It is set before the streams
+	// are sent.
+	query time.Duration
+}
+
 // make a set of streams ready to be sent to irelate.
-func makeStreams(receiver chan []interfaces.RelatableIterator, mustSort bool, A []interfaces.Relatable, lastChrom string, minStart int, maxEnd int, dbs ...interfaces.Queryable) {
+// If the context is cancelled before the streams are received, they are closed.
+func makeStreams(st *pstate, pc *pending, mustSort bool, less func(a, b interfaces.Relatable) bool, A []interfaces.Relatable, dbs ...interfaces.Queryable) {
+	defer close(pc.streams)
+	start := time.Now()
 
 	if mustSort {
-		sort.Sort(islice(A))
+		// stable so that intervals with the same extended bounds keep their order.
+		sort.SliceStable(A, func(i, j int) bool { return less(A[i], A[j]) })
 	}
 
 	streams := make([]interfaces.RelatableIterator, 0, len(dbs)+1)
 	streams = append(streams, sliceToIterator(A))
-	p := pos{lastChrom, minStart, maxEnd}
+	p := pos{pc.info.Chrom, pc.info.Start, pc.info.End}
 
 	for _, db := range dbs {
 		stream, err := db.Query(p)
 		if err != nil {
-			log.Fatal(err)
+			closeAll(streams)
+			st.fail(err)
+			return
 		}
 		streams = append(streams, stream)
 	}
-	receiver <- streams
-	close(receiver)
+	pc.query = time.Since(start)
+	select {
+	case pc.streams <- streams:
+	case <-st.done:
+		closeAll(streams)
+	}
+}
+
+func closeAll(streams []interfaces.RelatableIterator) {
+	for _, s := range streams {
+		s.Close()
+	}
+}
+
+// pstate tracks cancellation and the first error for a call to PIRelate.
+type pstate struct {
+	parent context.Context
+	// ctx is cancelled when parent is done, when an error occurs or when
+	// all intervals have been sent.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   <-chan struct{}
+	// logErr is used by PIRelate, which has no way to report errors; the
+	// first error is logged and the output is closed.
+	logErr bool
+
+	mu  sync.Mutex
+	err error
+	// wg tracks the goroutines started by pirelate.
+	wg sync.WaitGroup
+}
+
+func newPState(parent context.Context, logErr bool) *pstate {
+	ctx, cancel := context.WithCancel(parent)
+	return &pstate{parent: parent, ctx: ctx, cancel: cancel, done: ctx.Done(), logErr: logErr}
+}
+
+// fail records err (if it is the first) and stops all goroutines.
+func (st *pstate) fail(err error) {
+	st.mu.Lock()
+	first := st.err == nil
+	if first {
+		st.err = err
+	}
+	st.mu.Unlock()
+	if first && st.logErr {
+		log.Println("irelate:", err)
+	}
+	st.cancel()
+}
+
+// stopped is called when output stops early because st.done was closed.
+func (st *pstate) stopped() {
+	if err := st.parent.Err(); err != nil {
+		st.fail(err)
+	}
+}
+
+// spawn runs f in a goroutine that is tracked by st.wg.
+func (st *pstate) spawn(f func()) {
+	st.wg.Add(1)
+	go func() {
+		defer st.wg.Done()
+		f()
+	}()
+}
+
+func (st *pstate) Err() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err
 }
 
 func checkOverlap(a, b interfaces.Relatable) bool {
@@ -177,49 +257,338 @@ func (ci ciRel) End() uint32 {
 	return uint32(getEnd(ci.Relatable, int(ci.Relatable.End())))
 }
 
-// PIRelate implements a parallel IRelate
+// Strand returns the strand of the wrapped Relatable or '.' if it is not known.
+func (ci ciRel) Strand() byte {
+	return strand(ci.Relatable)
+}
+
+// AddRelatedEdge keeps e if the wrapped Relatable is an EdgeRelatable. The
+// Edge is relative to the extended interval.
+func (ci ciRel) AddRelatedEdge(e interfaces.Edge) {
+	if er, ok := ci.Relatable.(interfaces.EdgeRelatable); ok {
+		er.AddRelatedEdge(e)
+		return
+	}
+	ci.Relatable.AddRelated(e.Related)
+}
+
+func (ci ciRel) RelatedEdges() []interfaces.Edge {
+	if er, ok := ci.Relatable.(interfaces.EdgeRelatable); ok {
+		return er.RelatedEdges()
+	}
+	return nil
+}
+
+// PIRelateOptions configures PIRelateWithOptions. The fields that tune the
+// chunking and the number of chunks in flight use the default given in their
+// comment if they are 0.
+type PIRelateOptions struct {
+	// ChunkSize is the requested number of query intervals in each chunk.
+	ChunkSize int
+	// MaxGap starts a new chunk when the gap between query intervals exceeds it
+	// and the chunk has more than GapChunkSize intervals.
+	MaxGap int
+	// MinGap is the gap between query intervals (in bases) that ends a chunk
+	// with at least ChunkSize intervals (25), so that a chunk does not end
+	// inside a cluster of intervals.
+	MinGap int
+	// ChunkSlack ends a chunk with ChunkSize+ChunkSlack intervals whatever the
+	// gap (200).
+	ChunkSlack int
+	// GapChunkSize is the number of intervals a chunk must have to end at a gap
+	// of more than MaxGap (2048).
+	GapChunkSize int
+	// BreakGap ends a chunk of any size at a gap of more than BreakGap bases
+	// (10*MaxGap).
+	BreakGap int
+
+	// Adaptive sizes the chunks from the chunks that have been swept so that
+	// each takes about TargetLatency to sweep and so that a chunk ends at a gap
+	// that holds about GapIntervals database intervals. ChunkSize and MaxGap
+	// are used until the first chunk is done. Use it when the density of the
+	// databases is not known (e.g. exome queries against a whole-genome BAM).
+	Adaptive bool
+	// TargetLatency is the time to sweep a chunk in adaptive mode (20ms).
+	TargetLatency time.Duration
+	// MinChunkSize and MaxChunkSize bound the chunk size in adaptive mode (100
+	// and 100000).
+	MinChunkSize, MaxChunkSize int
+	// GapIntervals is the expected number of database intervals in a gap
+	// that ends a chunk in adaptive mode (1000).
+	GapIntervals int
+
+	// Workers sets the defaults of ChunkQueue and SubChunkQueue
+	// (runtime.GOMAXPROCS(0)).
+	Workers int
+	// ChunkQueue is the number of chunks that may be swept ahead of the chunk
+	// being sent (2+Workers/2).
+	ChunkQueue int
+	// SubChunkQueue is the number of sub-chunks of a chunk that may be sent
+	// to Fn ahead of the one being sent (Workers).
+	SubChunkQueue int
+	// SubChunkSize is the number of query intervals in each call to work,
+	// which calls Fn on each of them in its own goroutine (400).
+	SubChunkSize int
+	// OutputBuffer is the capacity of the returned channel (2048).
+	OutputBuffer int
+	// MaxChunks caps the number of query chunks that have been read but not
+	// completely sent. Reading the query stream blocks at the cap. 0 is no cap.
+	MaxChunks int
+	// MaxInFlight caps the total Size of the query intervals (with their
+	// related intervals) that have been swept but not sent. The sweeps, and so
+	// the reading of the databases, block at the cap; the chunk being sent may
+	// always go over it so that it can not be starved. 0 is no cap.
+	MaxInFlight int64
+	// Size is the cost of an interval against MaxInFlight. The default is the
+	// number of records: 1 plus the number of related intervals. Use an
+	// estimate of the bytes held to bound memory.
+	Size func(interfaces.Relatable) int64
+	// Unordered sends the intervals of each chunk as soon as the chunk is
+	// swept rather than in the order of the query. Each chunk is still in
+	// order; use PIRelateChunks to get the index of each chunk. Up to
+	// ChunkQueue chunks are swept at once.
+	Unordered bool
+	// Observer is sent progress and metrics events. If it is nil, the
+	// Observer from the context (see WithObserver) is used.
+	Observer Observer
+	// CIExtend uses the CIPOS and CIEND of variants to extend the query intervals.
+	CIExtend bool
+	// Fn is called on each query interval (with its related intervals) in parallel.
+	Fn func(interfaces.Relatable)
+	// CheckRelated is used in place of the default overlap test. Each query
+	// chunk and the database intervals in its region are on one chromosome.
+	CheckRelated func(a, b interfaces.Relatable) bool
+	// Less is used in place of the default order (by start, then end) within
+	// each chunk. The query stream must be sorted by it.
+	Less func(a, b interfaces.Relatable) bool
+	// Reach is the greatest distance at which CheckRelated can relate two
+	// intervals that do not overlap. The region queried from the databases is
+	// extended by Reach on each side of a chunk and intervals are kept in the
+	// sweep until they end more than Reach bases before the current position.
+	Reach uint32
+	// Mode selects the query intervals that are sent (see IRelateMode). It is
+	// ignored by KNN.
+	Mode Mode
+
+	// sweep replaces IRelate for each chunk (e.g. for KNN) and must apply Mode
+	// if it can. padLeft and padRight
+	// extend the region queried from the databases on each side of a chunk.
+	sweep             func(ctx context.Context, streams ...interfaces.RelatableIterator) interfaces.RelatableIterator
+	padLeft, padRight int
+}
+
+// withDefaults returns a copy of opts with the defaults set.
+func (opts *PIRelateOptions) withDefaults() *PIRelateOptions {
+	o := *opts
+	def := func(v *int, d int) {
+		if *v <= 0 {
+			*v = d
+		}
+	}
+	def(&o.MinGap, 25)
+	def(&o.ChunkSlack, 200)
+	def(&o.GapChunkSize, 2048)
+	def(&o.BreakGap, 10*o.MaxGap)
+	def(&o.MinChunkSize, 100)
+	def(&o.MaxChunkSize, 100000)
+	def(&o.GapIntervals, 1000)
+	if o.TargetLatency <= 0 {
+		o.TargetLatency = 20 * time.Millisecond
+	}
+	def(&o.Workers, runtime.GOMAXPROCS(0))
+	def(&o.ChunkQueue, 2+o.Workers/2)
+	def(&o.SubChunkQueue, o.Workers)
+	def(&o.SubChunkSize, 400)
+	def(&o.OutputBuffer, 2048)
+	return &o
+}
+
+// order returns Less or the default order if it is not set.
+func (opts *PIRelateOptions) order() func(a, b interfaces.Relatable) bool {
+	if opts.Less != nil {
+		return opts.Less
+	}
+	return less
+}
+
+// PIRelate implements a parallel IRelate. It has no way to report an error
+// from a Query or from the streams, so the first one is logged and the
+// returned channel is closed early; use PIRelateContext to get the error.
 func PIRelate(chunk int, maxGap int, qstream interfaces.RelatableIterator, ciExtend bool, fn func(interfaces.Relatable), dbs ...interfaces.Queryable) interfaces.RelatableChannel {
-	nprocs := runtime.GOMAXPROCS(-1)
+	st := newPState(context.Background(), true)
+	return pirelate(st, qstream, &PIRelateOptions{ChunkSize: chunk, MaxGap: maxGap, CIExtend: ciExtend, Fn: fn}, dbs...)
+}
+
+// PIRelateContext is the same as PIRelate except that once ctx is done, all
+// of the goroutines started by PIRelate stop, all open streams are closed and
+// the returned channel is closed. The returned function must be called after
+// the channel is closed; it reports ctx.Err() if the output was cut short by
+// ctx, or the first error from a Query or from the streams.
+func PIRelateContext(ctx context.Context, chunk int, maxGap int, qstream interfaces.RelatableIterator, ciExtend bool, fn func(interfaces.Relatable), dbs ...interfaces.Queryable) (interfaces.RelatableChannel, func() error) {
+	return PIRelateWithOptions(ctx, qstream, &PIRelateOptions{ChunkSize: chunk, MaxGap: maxGap, CIExtend: ciExtend, Fn: fn}, dbs...)
+}
+
+// PIRelateFunc is PIRelateWithOptions with the checkRelated and less functions
+// used by IRelate in place of the default overlap test and order (see
+// PIRelateOptions.CheckRelated and Less). reach must be at least the greatest
+// distance at which checkRelated relates intervals that do not overlap (e.g.
+// the window size) so that each chunk gets every database interval it may be
+// related to.
+func PIRelateFunc(ctx context.Context, checkRelated func(a, b interfaces.Relatable) bool, less func(a, b interfaces.Relatable) bool, reach uint32,
+	qstream interfaces.RelatableIterator, opts *PIRelateOptions, dbs ...interfaces.Queryable) (interfaces.RelatableChannel, func() error) {
+	o := *opts
+	o.CheckRelated, o.Less, o.Reach = checkRelated, less, reach
+	return PIRelateWithOptions(ctx, qstream, &o, dbs...)
+}
+
+// PIRelateWithOptions is the same as PIRelateContext with the arguments given
+// in opts.
+func PIRelateWithOptions(ctx context.Context, qstream interfaces.RelatableIterator, opts *PIRelateOptions, dbs ...interfaces.Queryable) (interfaces.RelatableChannel, func() error) {
+	st := newPState(ctx, false)
+	return pirelate(st, qstream, opts, dbs...), st.Err
+}
+
+// Chunk is a chunk of the query intervals from PIRelateChunks, in order. ID is
+// the index of the chunk in the query so that the chunks can be sorted.
+type Chunk struct {
+	ID        int
+	Intervals []interfaces.Relatable
+}
+
+// PIRelateChunks is the same as PIRelateWithOptions except that it sends each
+// chunk of query intervals whole. With opts.Unordered, the chunks are sent as
+// they are done; otherwise they are sent in order of ID. Chunks with no
+// intervals to send (e.g. with ReportUnrelated) are skipped.
+func PIRelateChunks(ctx context.Context, qstream interfaces.RelatableIterator, opts *PIRelateOptions, dbs ...interfaces.Queryable) (<-chan Chunk, func() error) {
+	st := newPState(ctx, false)
+	return pirelateOutput(st, qstream, opts, true, dbs...).chunks, st.Err
+}
+
+// output is where PIRelate sends its intervals: one at a time to intervals or,
+// if chunks is not nil, a Chunk at a time.
+type output struct {
+	intervals chan interfaces.Relatable
+	chunks    chan Chunk
+}
+
+func (o output) close() {
+	if o.chunks != nil {
+		close(o.chunks)
+	} else {
+		close(o.intervals)
+	}
+}
+
+// send sends r to o.intervals. It returns false if st is done first.
+func (o output) send(st *pstate, r interfaces.Relatable) bool {
+	select {
+	case o.intervals <- r:
+		return true
+	case <-st.done:
+		st.stopped()
+		return false
+	}
+}
+
+// sendChunk sends c to o.chunks. It returns false if st is done first.
+func (o output) sendChunk(st *pstate, c Chunk) bool {
+	select {
+	case o.chunks <- c:
+		return true
+	case <-st.done:
+		st.stopped()
+		return false
+	}
+}
+
+// swept is a chunk of query intervals with the sub-chunks of its sweep in
+// order.
+type swept struct {
+	id      int
+	batches chan chan batch
+}
+
+func pirelate(st *pstate, qstream interfaces.RelatableIterator, opts *PIRelateOptions, dbs ...interfaces.Queryable) interfaces.RelatableChannel {
+	return pirelateOutput(st, qstream, opts, false, dbs...).intervals
+}
+
+func pirelateOutput(st *pstate, qstream interfaces.RelatableIterator, opts *PIRelateOptions, chunked bool, dbs ...interfaces.Queryable) output {
+	fixedBreak := opts.BreakGap > 0
+	opts = opts.withDefaults()
+	ciExtend, fn := opts.CIExtend, opts.Fn
+	checkRelated := opts.CheckRelated
+	if checkRelated == nil {
+		checkRelated = checkOverlap
+	}
+	order := opts.order()
+	sweep := opts.sweep
+	if sweep == nil {
+		sweep = func(ctx context.Context, streams ...interfaces.RelatableIterator) interfaces.RelatableIterator {
+			return newIRelate(ctx, opts.Mode, opts.Reach, relativeToMatrix(0, len(streams)), checkRelated, order, streams...)
+		}
+	}
+	padLeft, padRight := max(opts.padLeft, int(opts.Reach)), max(opts.padRight, int(opts.Reach))
+	obs := opts.Observer
+	if obs == nil {
+		obs = observerFor(st.parent)
+	}
+	// the sweeps of the chunks are not observed; their events are sent here.
+	sweepCtx := WithObserver(st.ctx, NopObserver{})
+	chunks := newChunker(opts, fixedBreak, padLeft+padRight, obs)
+	lim := newLimits(st.ctx, opts)
+	// region returns the padded region to query for a chunk.
+	region := func(minStart, maxEnd int) (int, int) {
+		return max(minStart-padLeft, 0), maxEnd + padRight
+	}
 	// final interval stream sent back to caller.
-	intersected := make(chan interfaces.Relatable, 2048)
+	var out output
+	if chunked {
+		out.chunks = make(chan Chunk, opts.ChunkQueue)
+	} else {
+		out.intervals = make(chan interfaces.Relatable, opts.OutputBuffer)
+	}
 
 	// receivers keeps the interval chunks in order.
-	receivers := make(chan chan []interfaces.RelatableIterator, 1)
+	receivers := make(chan *pending, 1)
 
 	// to channels recieves channels that accept intervals from IRelate to be sent for merging.
 	// we send slices of intervals to reduce locking.
-	tochannels := make(chan chan chan []interfaces.Relatable, 2+nprocs/2)
-
-	verbose := os.Getenv("IRELATE_VERBOSE") == "TRUE"
+	tochannels := make(chan swept, opts.ChunkQueue)
 
 	// the user-defined callback runs int it's own goroutine.
 	// call on the relatable itself. but with all of the associated intervals.
-	work := func(rels []interfaces.Relatable, fn func(interfaces.Relatable)) chan []interfaces.Relatable {
-		ch := make(chan []interfaces.Relatable, 0)
-		go func() {
+	work := func(b batch, fn func(interfaces.Relatable)) chan batch {
+		ch := make(chan batch, 0)
+		st.spawn(func() {
 			if fn != nil {
-				for _, r := range rels {
+				for _, r := range b.rels {
 					fn(r)
 				}
 			}
-			ch <- rels
+			select {
+			case ch <- b:
+			case <-st.done:
+			}
 			close(ch)
-		}()
+		})
 		return ch
 	}
 	if ciExtend {
 
-		work = func(rels []interfaces.Relatable, fn func(interfaces.Relatable)) chan []interfaces.Relatable {
-			ch := make(chan []interfaces.Relatable, 0)
-			go func() {
+		work = func(b batch, fn func(interfaces.Relatable)) chan batch {
+			ch := make(chan batch, 0)
+			st.spawn(func() {
 				if fn != nil {
-					for _, r := range rels {
+					for _, r := range b.rels {
 						fn(r.(ciRel).Relatable)
 					}
 				}
-				ch <- rels
+				select {
+				case ch <- b:
+				case <-st.done:
+				}
 				close(ch)
-			}()
+			})
 			return ch
 
 		}
@@ -229,64 +598,143 @@ func PIRelate(chunk int, maxGap int, qstream interfaces.RelatableIterator, ciExt
 	// calling fn() is a bottleneck. so we make sub-chunks and process them in a separate go-routine
 	// in work()
 	// inner channel keeps track of the order for each big chunk
-	go func() {
+	st.spawn(func() {
+		defer close(tochannels)
 
-		for streamsChan := range receivers {
+		for pc := range receivers {
+			chunk := pc.info.ID
 
-			inner := make(chan chan []interfaces.Relatable, nprocs)
-			tochannels <- inner
+			// with Unordered, the queue does not hold the chunks being swept.
+			if !lim.startSweep(st.done) {
+				return
+			}
+			inner := make(chan chan batch, opts.SubChunkQueue)
+			select {
+			case tochannels <- swept{chunk, inner}:
+			case <-st.done:
+				return
+			}
 
 			// push a channel to to channels out here
 			// and then push to that channel inside this goroutine.
 			// this maintains order of the intervals.
-			go func(streams []interfaces.RelatableIterator) {
-				N := 400
-				//saved := make([]interfaces.Relatable, N)
-				iterator := IRelate(checkOverlap, 0, less, streams...)
+			streams := <-pc.streams // only one, just used a chan for ordering.
+			st.spawn(func() {
+				defer close(inner)
+				// streams is nil if makeStreams was stopped.
+				if streams == nil {
+					return
+				}
+				N := opts.SubChunkSize
+				streams, cost := chunks.track(pc, streams)
+				iterator := sweep(sweepCtx, streams...)
+				defer iterator.Close()
 				saved := make([]interfaces.Relatable, N)
 				k := 0
+				// size is the cost of saved against MaxInFlight.
+				var size int64
+				flush := func() bool {
+					select {
+					case inner <- work(batch{saved[:k], size}, fn):
+					case <-st.done:
+						return false
+					}
+					k, size = 0, 0
+					saved = make([]interfaces.Relatable, N)
+					return true
+				}
 
 				for {
+					cost.begin()
 					interval, err := iterator.Next()
+					cost.end()
 					if err == io.EOF {
-						iterator.Close()
+						cost.done()
 						break
 					}
+					if err != nil {
+						if st.ctx.Err() == nil {
+							st.fail(err)
+						}
+						return
+					}
+					// wait while too much is in flight so that the sweep
+					// stops reading the databases.
+					n := lim.size(interval)
+					if k > 0 && lim.cost.full(chunk, n) && !flush() {
+						return
+					}
+					if !lim.cost.acquire(chunk, n) {
+						return
+					}
+					size += n
 					saved[k] = interval
 					k++
 
-					if k == N {
-						inner <- work(saved, fn)
-						k = 0
-						saved = make([]interfaces.Relatable, N)
+					if k == N && !flush() {
+						return
 					}
 
 				}
 				if k > 0 {
-					inner <- work(saved[:k], fn)
+					flush()
 				}
-				close(inner)
-			}(<-streamsChan) // only one, just used a chan for ordering.
+			})
 		}
-		close(tochannels)
-	}()
+	})
 
-	go mergeIntervals(tochannels, intersected, ciExtend)
+	st.spawn(func() { mergeIntervals(st, tochannels, out, ciExtend, opts.Unordered, lim) })
 
 	// split the query intervals into chunks and send for processing to irelate.
-	go func() {
+	st.spawn(func() {
+		defer close(receivers)
+		defer qstream.Close()
 
-		A := make([]interfaces.Relatable, 0, chunk/2)
+		p := chunks.params()
+		A := make([]interfaces.Relatable, 0, p.size/2)
 
 		lastStart := -10
 		lastChrom := ""
 		minStart := int(^uint32(0) >> 1)
 		maxEnd := 0
-		var totalParsed, totalSkipped, c, idx int
+		var c, idx int
+		// prevChrom and prevEnd give the region of the last chunk so that the
+		// bases skipped can be counted.
+		prevChrom, prevEnd := "", 0
+		// sendChunk sends A to be related. It returns false if st is done.
+		sendChunk := func(A []interfaces.Relatable, chrom string, minStart, maxEnd int) bool {
+			// reading blocks while MaxChunks are in flight.
+			if !lim.startChunk(st.done) {
+				return false
+			}
+			rs, re := region(minStart, maxEnd)
+			pc := &pending{streams: make(chan []interfaces.RelatableIterator, 0), created: time.Now(),
+				info: ChunkInfo{ID: c, Chrom: chrom, Start: rs, End: re, Queries: len(A), Parsed: re - rs}}
+			if chrom == prevChrom && rs > prevEnd {
+				pc.info.Skipped = rs - prevEnd
+			}
+			prevChrom, prevEnd = chrom, re
+			// we push a channel onto a queue (another channel) and use that as the output order.
+			select {
+			case receivers <- pc:
+			case <-st.done:
+				return false
+			}
+			// send work to IRelate
+			st.spawn(func() { makeStreams(st, pc, ciExtend, order, A, dbs...) })
+			c++
+			if obs != nil {
+				obs.ChunkCreated(pc.info)
+				obs.Queues(QueueDepths{Reading: len(receivers), Sweeping: len(tochannels),
+					Output: len(out.intervals) + len(out.chunks), InFlight: lim.cost.inUse()})
+			}
+			return true
+		}
 		for {
 			v, err := qstream.Next()
-			if err == io.EOF {
-				qstream.Close()
+			if err != nil && err != io.EOF {
+				st.fail(&ErrSource{Source: 0, Err: err})
+				return
 			}
 			if v == nil {
 				break
@@ -303,34 +751,20 @@ func PIRelate(chunk int, maxGap int, qstream interfaces.RelatableIterator, ciExt
 
 			// end chunk when:
 			// 1. switch chroms
-			// 2. see maxGap bases between adjacent intervals (currently looks at start only)
-			// 3. reaches chunkSize (and has at least a gap of 2 bases from last interval).
-			if v.Chrom() != lastChrom || (len(A) > 2048 && s-lastStart > maxGap) || ((s-lastStart > 25 && len(A) >= chunk) || len(A) >= chunk+200) || s-lastStart > 10*maxGap {
-				if len(A) > 0 {
-					// we push a channel onto a queue (another channel) and use that as the output order.
-					ch := make(chan []interfaces.RelatableIterator, 0)
-					receivers <- ch
-					// send work to IRelate
-					go makeStreams(ch, ciExtend, A, lastChrom, minStart, maxEnd, dbs...)
-					c++
-					if verbose {
-						if lastChrom == v.Chrom() {
-							totalSkipped += s - lastStart
-						}
-						totalParsed += maxEnd - minStart
-						var mem runtime.MemStats
-						runtime.ReadMemStats(&mem)
-						log.Println("intervals in current chunk:", len(A), fmt.Sprintf("%s:%d-%d", lastChrom, minStart, maxEnd), "gap:", s-lastStart)
-						log.Println("\tc:", c, "receivers:", len(receivers), "tochannels:", len(tochannels), "intersected:", len(intersected))
-						log.Printf("\tmemory use: %dMB , heap in use: %dMB\n", mem.Alloc/uint64(1000*1000),
-							mem.HeapInuse/uint64(1000*1000))
-						log.Printf("\ttotal bases skipped / parsed: %d / %d (%.2f)\n", totalSkipped, totalParsed, float64(totalSkipped)/float64(totalParsed))
-					}
-
+			// 2. see a large gap between adjacent intervals (currently looks at start only)
+			// 3. reaches chunkSize (and has at least a small gap from last interval).
+			// see chunkParams.ends.
+			if v.Chrom() != lastChrom || p.ends(len(A), s-lastStart) {
+				if len(A) > 0 && !sendChunk(A, lastChrom, minStart, maxEnd) {
+					return
+				}
+				if obs != nil && v.Chrom() != lastChrom {
+					obs.Chrom(lastChrom, v.Chrom())
 				}
 				lastStart = s
 				lastChrom, minStart, maxEnd = v.Chrom(), s, e
-				A = make([]interfaces.Relatable, 0, chunk/2)
+				p = chunks.params()
+				A = make([]interfaces.Relatable, 0, p.size/2)
 			} else {
 				lastStart = s
 				maxEnd = max(e, maxEnd)
@@ -341,30 +775,86 @@ func PIRelate(chunk int, maxGap int, qstream interfaces.RelatableIterator, ciExt
 		}
 
 		if len(A) > 0 {
-			ch := make(chan []interfaces.RelatableIterator, 0)
-			receivers <- ch
-			go makeStreams(ch, ciExtend, A, lastChrom, minStart, maxEnd, dbs...)
-			c++
+			sendChunk(A, lastChrom, minStart, maxEnd)
 		}
-		close(receivers)
-	}()
-	return intersected
+	})
+	return out
 }
 
-func mergeIntervals(tochannels chan chan chan []interfaces.Relatable, intersected chan interfaces.Relatable, ciExtend bool) {
-	// merge the intervals from different channels keeping order.
-	// 2 separate function code-blocks so there is no performance hit when they don't
-	// care about the cipos.
+// drainChunk sends the intervals of c in order and releases their cost. With
+// ciExtend, they are sorted by their index in the query.
+func drainChunk(st *pstate, c swept, out output, ciExtend bool, lim *limits) bool {
+	var rels []interfaces.Relatable
+	var cost int64
+	// without ciExtend or chunks, intervals are sent as each batch is done.
+	whole := ciExtend || out.chunks != nil
+	for ch := range c.batches {
+		for b := range ch {
+			if whole {
+				rels = append(rels, b.rels...)
+				cost += b.cost
+				continue
+			}
+			for _, interval := range b.rels {
+				if !out.send(st, interval) {
+					return false
+				}
+			}
+			lim.cost.release(b.cost)
+		}
+	}
+	defer lim.cost.release(cost)
 	if ciExtend {
+		sort.Slice(rels, func(i, j int) bool { return rels[i].(ciRel).index < rels[j].(ciRel).index })
+		for i, r := range rels {
+			rels[i] = r.(ciRel).Relatable
+		}
+	}
+	if out.chunks != nil {
+		return len(rels) == 0 || out.sendChunk(st, Chunk{ID: c.id, Intervals: rels})
+	}
+	for _, interval := range rels {
+		if !out.send(st, interval) {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeIntervals(st *pstate, tochannels chan swept, out output, ciExtend, unordered bool, lim *limits) {
+	defer out.close()
+	// cancel releases the context once everything has been sent. if output
+	// was stopped early, the error is recorded first.
+	defer st.cancel()
+	send := func(r interfaces.Relatable) bool { return out.send(st, r) }
+	// merge the intervals from different channels keeping order (unless
+	// unordered). 2 separate function code-blocks so there is no performance
+	// hit when they don't care about the cipos.
+	if unordered {
+		// each chunk is sent as soon as it is done.
+		var wg sync.WaitGroup
+		for c := range tochannels {
+			wg.Add(1)
+			st.spawn(func() {
+				defer wg.Done()
+				if drainChunk(st, c, out, ciExtend, lim) {
+					lim.endChunk(c.id)
+				}
+			})
+		}
+		wg.Wait()
+	} else if ciExtend && out.chunks == nil {
 		nextPrint := 0
 		q := make(map[int]ciRel, 100)
-		for och := range tochannels {
-			for ch := range och {
-				for intervals := range ch {
-					for _, interval := range intervals {
+		for c := range tochannels {
+			for ch := range c.batches {
+				for b := range ch {
+					for _, interval := range b.rels {
 						ci := interval.(ciRel)
 						if ci.index == nextPrint {
-							intersected <- ci.Relatable
+							if !send(ci.Relatable) {
+								return
+							}
 							nextPrint++
 						} else {
 							q[ci.index] = ci
@@ -374,7 +864,9 @@ func mergeIntervals(tochannels chan chan chan []interfaces.Relatable, intersecte
 									break
 								}
 								delete(q, nextPrint)
-								intersected <- n.Relatable
+								if !send(n.Relatable) {
+									return
+								}
 								nextPrint++
 							}
 						}
@@ -386,22 +878,45 @@ func mergeIntervals(tochannels chan chan chan []interfaces.Relatable, intersecte
 							break
 						}
 						delete(q, nextPrint)
-						intersected <- n.Relatable
+						if !send(n.Relatable) {
+							return
+						}
 						nextPrint++
 					}
+					lim.cost.release(b.cost)
 				}
 			}
-		}
-	} else {
-		for och := range tochannels {
-			for ch := range och {
-				for intervals := range ch {
-					for _, interval := range intervals {
-						intersected <- interval
+			// with a Mode other than ReportAll, some indexes are never sent
+			// so anything left from this chunk is sent in order here.
+			if len(q) > 0 {
+				idxs := make([]int, 0, len(q))
+				for i := range q {
+					idxs = append(idxs, i)
+				}
+				sort.Ints(idxs)
+				for _, i := range idxs {
+					n := q[i]
+					delete(q, i)
+					if !send(n.Relatable) {
+						return
 					}
 				}
+				nextPrint = idxs[len(idxs)-1] + 1
 			}
+			lim.endChunk(c.id)
 		}
+	} else {
+		for c := range tochannels {
+			if !drainChunk(st, c, out, ciExtend, lim) {
+				return
+			}
+			lim.endChunk(c.id)
+		}
+	}
+	// an error may have stopped the goroutines that feed tochannels.
+	select {
+	case <-st.done:
+		st.stopped()
+	default:
 	}
-	close(intersected)
 }
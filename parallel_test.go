@@ -0,0 +1,489 @@
+package irelate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+)
+
+// sliceQueryable is an in-memory Queryable that counts open streams.
+type sliceQueryable struct {
+	rels []Relatable
+	open *int32
+}
+
+type countIt struct {
+	RelatableIterator
+	open *int32
+	done bool
+}
+
+func (c *countIt) Close() error {
+	if !c.done {
+		c.done = true
+		atomic.AddInt32(c.open, -1)
+	}
+	return nil
+}
+
+func (q sliceQueryable) Query(p IPosition) (RelatableIterator, error) {
+	out := make([]Relatable, 0, 8)
+	for _, r := range q.rels {
+		if SameChrom(r.Chrom(), p.Chrom()) && r.End() > p.Start() && r.Start() < p.End() {
+			// copy so that concurrent queries do not share intervals.
+			out = append(out, parsers.NewInterval(r.Chrom(), r.Start(), r.End(), r.(*parsers.Interval).Fields, 0, nil))
+		}
+	}
+	atomic.AddInt32(q.open, 1)
+	return &countIt{RelatableIterator: sliceToIterator(out), open: q.open}, nil
+}
+
+func newSliceQueryable(rels []Relatable) sliceQueryable {
+	return sliceQueryable{rels: rels, open: new(int32)}
+}
+
+func spaced(chrom string, n int, step, length uint32) []Relatable {
+	rels := make([]Relatable, n)
+	for i := range rels {
+		s := uint32(i) * step
+		rels[i] = iv(chrom, s, s+length)
+	}
+	return rels
+}
+
+func TestPIRelateContext(t *testing.T) {
+	db := newSliceQueryable(spaced("chr1", 2000, 10, 15))
+	ch, errf := PIRelateContext(context.Background(), 100, 1000, ivs(spaced("chr1", 1000, 20, 5)...), false, nil, db)
+	n := 0
+	for r := range ch {
+		if len(r.Related()) == 0 {
+			t.Errorf("expected overlap for %s:%d-%d", r.Chrom(), r.Start(), r.End())
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("expected 1000 intervals, got %d", n)
+	}
+	if err := errf(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if o := atomic.LoadInt32(db.open); o != 0 {
+		t.Errorf("expected all streams to be closed, %d open", o)
+	}
+}
+
+func TestPIRelateContextCancel(t *testing.T) {
+	base := runtime.NumGoroutine()
+	db := newSliceQueryable(spaced("chr1", 200000, 10, 15))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, errf := PIRelateContext(ctx, 100, 1000, ivs(spaced("chr1", 100000, 20, 5)...), false, nil, db)
+	n := 0
+	for range ch {
+		n++
+		if n == 10 {
+			cancel()
+		}
+	}
+	if n == 100000 {
+		t.Fatal("expected output to stop early")
+	}
+	if err := errf(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g > base {
+		t.Errorf("goroutines leaked: %d > %d", g, base)
+	}
+	if o := atomic.LoadInt32(db.open); o != 0 {
+		t.Errorf("expected all streams to be closed, %d open", o)
+	}
+}
+
+// errQueryable fails every query.
+type errQueryable struct{ err error }
+
+func (q errQueryable) Query(p IPosition) (RelatableIterator, error) {
+	return nil, q.err
+}
+
+func TestPIRelateError(t *testing.T) {
+	bad := errors.New("bad query")
+	// PIRelate logs the error and closes the channel rather than exiting.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	n := 0
+	for range PIRelate(100, 1000, ivs(spaced("chr1", 1000, 20, 5)...), false, nil, errQueryable{bad}) {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("expected no output, got %d intervals", n)
+	}
+
+	ch, errf := PIRelateContext(context.Background(), 100, 1000, ivs(spaced("chr1", 1000, 20, 5)...), false, nil, errQueryable{bad})
+	for range ch {
+	}
+	if err := errf(); err != bad {
+		t.Errorf("expected the query error, got %v", err)
+	}
+}
+
+func TestIRelateContextCancel(t *testing.T) {
+	open := new(int32)
+	*open = 2
+	a := &countIt{RelatableIterator: ivs(spaced("chr1", 100, 20, 5)...), open: open}
+	b := &countIt{RelatableIterator: ivs(spaced("chr1", 100, 10, 15)...), open: open}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := IRelateContext(ctx, CheckRelatedByOverlap, 0, Less, a, b)
+	if _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := it.Next(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if *open != 0 {
+		t.Errorf("expected streams to be closed, %d open", *open)
+	}
+}
+
+func TestPIRelateFunc(t *testing.T) {
+	const window = 150
+	// within relates intervals that are no more than window bases apart.
+	within := func(a, b Relatable) bool {
+		return b.Start() < a.End()+window && a.Start() < b.End()+window
+	}
+	// sparse queries so that many chunks are far from the database intervals
+	// they are related to.
+	query := spaced("chr1", 300, 1000, 5)
+	dbRels := make([]Relatable, 600)
+	for i := range dbRels {
+		s := uint32(i)*500 + 100
+		dbRels[i] = iv("chr1", s, s+10)
+	}
+	for _, reach := range []uint32{0, window} {
+		db := newSliceQueryable(dbRels)
+		qcopy := make([]Relatable, len(query))
+		for i, q := range query {
+			qcopy[i] = iv(q.Chrom(), q.Start(), q.End())
+		}
+		ch, errf := PIRelateFunc(context.Background(), within, Less, reach, ivs(qcopy...), &PIRelateOptions{ChunkSize: 5, MaxGap: 100}, db)
+		missing := 0
+		n := 0
+		for r := range ch {
+			want := 0
+			for _, d := range dbRels {
+				if within(r, d) {
+					want++
+				}
+			}
+			if got := len(r.Related()); got != want {
+				if reach == window {
+					t.Errorf("expected %d relations for %d-%d, got %d", want, r.Start(), r.End(), got)
+				}
+				missing++
+			}
+			n++
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		if n != len(query) {
+			t.Errorf("expected %d queries, got %d", len(query), n)
+		}
+		// without reach, the database intervals beyond each chunk are not found.
+		if reach == 0 && missing == 0 {
+			t.Errorf("expected relations to be missed without reach")
+		}
+		if o := atomic.LoadInt32(db.open); o != 0 {
+			t.Errorf("expected all streams to be closed, %d open", o)
+		}
+	}
+
+	// strand-aware relations with the same results as IRelate.
+	q := []Relatable{stranded("chr1", 10, 20, "+"), stranded("chr1", 30, 40, "-"), stranded("chr1", 50, 60, "+")}
+	d := []Relatable{stranded("chr1", 15, 35, "+"), stranded("chr1", 35, 55, "-")}
+	ch, errf := PIRelateFunc(context.Background(), CheckRelatedByOverlapSameStrand, Less, 0, ivs(q...), &PIRelateOptions{ChunkSize: 1, MaxGap: 1}, newSliceQueryable(d))
+	var got []int
+	for r := range ch {
+		got = append(got, len(r.Related()))
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[1 1 0]" {
+		t.Errorf("expected [1 1 0] strand-aware relations, got %v", got)
+	}
+}
+
+func TestChunkParams(t *testing.T) {
+	opts := (&PIRelateOptions{ChunkSize: 100, MaxGap: 1000}).withDefaults()
+	p := newChunker(opts, false, 0, nil).params()
+	// the fixed rules of earlier versions.
+	old := func(n, gap int) bool {
+		return (n > 2048 && gap > 1000) || ((gap > 25 && n >= 100) || n >= 100+200) || gap > 10*1000
+	}
+	for _, n := range []int{0, 1, 99, 100, 299, 300, 2048, 2049, 5000} {
+		for _, gap := range []int{0, 25, 26, 1000, 1001, 10000, 10001} {
+			if p.ends(n, gap) != old(n, gap) {
+				t.Errorf("n: %d gap: %d: expected %v", n, gap, old(n, gap))
+			}
+		}
+	}
+
+	opts = (&PIRelateOptions{ChunkSize: 100, MaxGap: 1000, Adaptive: true, TargetLatency: time.Millisecond}).withDefaults()
+	c := newChunker(opts, false, 0, nil)
+	if c.params() != p {
+		t.Errorf("expected the given sizes before the first chunk is done")
+	}
+	// dense: 10 database intervals per base and 1ms per query.
+	c.observe(100, 1000, 10000, 100*time.Millisecond)
+	dense := c.params()
+	if dense.size != opts.MinChunkSize || dense.maxGap != 100 || dense.breakGap != 1000 {
+		t.Errorf("unexpected dense params: %+v", dense)
+	}
+	// sparse: 1 database interval per 10KB and 1µs per query.
+	c = newChunker(opts, true, 0, nil)
+	c.observe(1000, 1000000, 100, time.Millisecond)
+	sparse := c.params()
+	if sparse.size != 1000 || sparse.maxGap != 10000000 || sparse.breakGap != opts.BreakGap {
+		t.Errorf("unexpected sparse params: %+v", sparse)
+	}
+}
+
+func TestPIRelateAdaptive(t *testing.T) {
+	// sparse clusters of queries against a dense database.
+	var query []Relatable
+	for c := uint32(0); c < 50; c++ {
+		for i := uint32(0); i < 40; i++ {
+			s := c*20000 + i*30
+			query = append(query, iv("chr1", s, s+20))
+		}
+	}
+	db := newSliceQueryable(spaced("chr1", 100000, 10, 15))
+	run := func(opts *PIRelateOptions) []int {
+		qcopy := make([]Relatable, len(query))
+		for i, q := range query {
+			qcopy[i] = iv(q.Chrom(), q.Start(), q.End())
+		}
+		ch, errf := PIRelateWithOptions(context.Background(), ivs(qcopy...), opts, db)
+		var counts []int
+		for r := range ch {
+			counts = append(counts, len(r.Related()))
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		return counts
+	}
+	want := run(&PIRelateOptions{ChunkSize: 100, MaxGap: 1000})
+	if len(want) != len(query) {
+		t.Fatalf("expected %d queries, got %d", len(query), len(want))
+	}
+	for _, opts := range []*PIRelateOptions{
+		{ChunkSize: 100, MaxGap: 1000, Adaptive: true},
+		{ChunkSize: 7, MaxGap: 100000, Adaptive: true, MinChunkSize: 3, TargetLatency: time.Microsecond},
+		{ChunkSize: 100, MaxGap: 1000, Workers: 1, SubChunkSize: 7, OutputBuffer: 1},
+	} {
+		if got := run(opts); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%+v: results differ from the default options", opts)
+		}
+	}
+}
+
+// slowIt counts (atomically) the intervals read from a stream.
+type slowIt struct {
+	RelatableIterator
+	n *int64
+}
+
+func (s slowIt) Next() (Relatable, error) {
+	v, err := s.RelatableIterator.Next()
+	if err == nil {
+		atomic.AddInt64(s.n, 1)
+	}
+	return v, err
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(context.Background(), 10)
+	if !b.acquire(0, 8) || !b.acquire(1, 2) {
+		t.Fatal("expected acquire within the budget to succeed")
+	}
+	// the head chunk may go over the budget.
+	if !b.acquire(0, 100) {
+		t.Fatal("expected the head chunk to go over the budget")
+	}
+	got := make(chan bool)
+	go func() { got <- b.acquire(1, 1) }()
+	select {
+	case <-got:
+		t.Fatal("expected acquire to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.release(108)
+	if !<-got {
+		t.Fatal("expected acquire to succeed after release")
+	}
+	// the head moves past chunks that are sent out of order.
+	b.release(3)
+	b.acquire(2, 20)
+	b.done(1)
+	if !b.full(2, 1) {
+		t.Error("expected chunk 2 to wait until chunk 0 is sent")
+	}
+	b.done(0)
+	if b.full(2, 1) {
+		t.Error("expected chunk 2 to be the head")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b = newBudget(ctx, 1)
+	b.acquire(0, 1)
+	go func() { got <- b.acquire(1, 1) }()
+	cancel()
+	if <-got {
+		t.Error("expected acquire to fail once the context is done")
+	}
+	if newBudget(ctx, 0) != nil || !(*budget)(nil).acquire(5, 1<<40) {
+		t.Error("expected no budget for a limit of 0")
+	}
+}
+
+func TestPIRelateLimits(t *testing.T) {
+	db := newSliceQueryable(spaced("chr1", 40000, 5, 15))
+	want := -1
+	for _, opts := range []*PIRelateOptions{
+		{ChunkSize: 100, MaxGap: 1000},
+		{ChunkSize: 100, MaxGap: 1000, MaxChunks: 1, MaxInFlight: 1},
+		{ChunkSize: 50, MaxGap: 1000, ChunkSlack: 10, MaxChunks: 3, MaxInFlight: 500, SubChunkSize: 7},
+		{ChunkSize: 100, MaxGap: 1000, CIExtend: true, MaxInFlight: 64,
+			Size: func(r Relatable) int64 { return int64(16 * (1 + len(r.Related()))) }},
+	} {
+		ch, errf := PIRelateWithOptions(context.Background(), ivs(spaced("chr1", 10000, 20, 5)...), opts, db)
+		total := 0
+		for r := range ch {
+			total += len(r.Related())
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		if want == -1 {
+			want = total
+		} else if total != want {
+			t.Errorf("%+v: expected %d related intervals, got %d", opts, want, total)
+		}
+	}
+
+	// with a stalled consumer, only MaxChunks chunks (and the one being
+	// built) are read from the query.
+	read := new(int64)
+	opts := &PIRelateOptions{ChunkSize: 100, MaxGap: 1000, ChunkSlack: 10, MaxChunks: 2, OutputBuffer: 1}
+	ch, errf := PIRelateWithOptions(context.Background(), slowIt{ivs(spaced("chr1", 10000, 20, 5)...), read}, opts, db)
+	<-ch
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(read); n > 3*110+1 {
+		t.Errorf("expected reading to block at MaxChunks, read %d", n)
+	}
+	n := 1
+	for range ch {
+		n++
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10000 {
+		t.Errorf("expected 10000 intervals, got %d", n)
+	}
+}
+
+func TestPIRelateUnordered(t *testing.T) {
+	db := newSliceQueryable(spaced("chr1", 40000, 5, 15))
+	query := func() RelatableIterator { return ivs(spaced("chr1", 10000, 20, 5)...) }
+	key := func(r Relatable) string { return fmt.Sprintf("%d:%d", r.Start(), len(r.Related())) }
+	var want []string
+	ch, errf := PIRelateWithOptions(context.Background(), query(), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000}, db)
+	for r := range ch {
+		want = append(want, key(r))
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow Fn on the first chunk lets the others finish first.
+	slow := func(r Relatable) {
+		if r.Start() < 100 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for _, opts := range []*PIRelateOptions{
+		{ChunkSize: 100, MaxGap: 1000, Unordered: true, Fn: slow},
+		{ChunkSize: 100, MaxGap: 1000, Unordered: true, CIExtend: true, MaxInFlight: 50, MaxChunks: 4, Workers: 8},
+	} {
+		ch, errf := PIRelateWithOptions(context.Background(), query(), opts, db)
+		var got []string
+		for r := range ch {
+			got = append(got, key(r))
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%+v: expected %d intervals, got %d", opts, len(want), len(got))
+		}
+		if opts.Fn != nil && got[0] == want[0] {
+			t.Errorf("expected the first chunk to be sent later")
+		}
+		sort.Strings(got)
+		w := append([]string{}, want...)
+		sort.Strings(w)
+		if fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Errorf("%+v: results differ from the ordered output", opts)
+		}
+	}
+
+	for _, opts := range []*PIRelateOptions{
+		{ChunkSize: 100, MaxGap: 1000},
+		{ChunkSize: 100, MaxGap: 1000, Unordered: true, Fn: slow, MaxInFlight: 100},
+	} {
+		chunks, errf := PIRelateChunks(context.Background(), query(), opts, db)
+		var all []Chunk
+		for c := range chunks {
+			all = append(all, c)
+		}
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		if !opts.Unordered && !sort.SliceIsSorted(all, func(i, j int) bool { return all[i].ID < all[j].ID }) {
+			t.Errorf("expected the chunks in order")
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+		var got []string
+		for i, c := range all {
+			if c.ID != i {
+				t.Fatalf("expected chunk %d, got %d", i, c.ID)
+			}
+			for _, r := range c.Intervals {
+				got = append(got, key(r))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%+v: sorted chunks differ from the ordered output", opts)
+		}
+	}
+}
@@ -3,8 +3,10 @@
 package parsers
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"iter"
 	"log"
 	"os"
 	"strings"
@@ -13,12 +15,14 @@ import (
 	"github.com/biogo/hts/bgzf/index"
 	"github.com/biogo/hts/sam"
 	"github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/iterators"
 )
 
 type Bam struct {
 	*sam.Record
 	source     uint32
 	related    []interfaces.Relatable
+	edges      []interfaces.Edge
 	Chromosome string
 	_end       uint32
 }
@@ -32,6 +36,7 @@ func (a *Bam) Start() uint32 {
 	return uint32(a.Record.Start())
 }
 
+// End is one past the last reference base covered by the read (see BamCoords).
 func (a *Bam) End() uint32 {
 	if a._end != 0 {
 		return a._end
@@ -60,10 +65,124 @@ func (a *Bam) Related() []interfaces.Relatable {
 	return a.related
 }
 
+// AddRelatedEdge adds e.Related to Related() and keeps e.
+func (a *Bam) AddRelatedEdge(e interfaces.Edge) {
+	a.AddRelated(e.Related)
+	a.edges = append(a.edges, e)
+}
+
+// RelatedEdges returns the edges in the same order as Related().
+func (a *Bam) RelatedEdges() []interfaces.Edge {
+	return a.edges
+}
+
+// Strand is '-' if the read is reverse complemented and '+' otherwise.
+func (a *Bam) Strand() byte {
+	if a.Record.Flags&sam.Reverse != 0 {
+		return '-'
+	}
+	return '+'
+}
+
 func (a *Bam) MapQ() int {
 	return int(a.Record.MapQ)
 }
 
+// NewCodec returns a Codec for Bams. The references of the decoded records
+// are those of the records that were encoded by the same Codec.
+func (a *Bam) NewCodec() (interfaces.Codec, error) {
+	return &bamCodec{refs: make(map[string]*sam.Reference)}, nil
+}
+
+type bamCodec struct {
+	refs map[string]*sam.Reference
+}
+
+func (c *bamCodec) appendRef(dst []byte, ref *sam.Reference) []byte {
+	if ref == nil {
+		return appendString(dst, "")
+	}
+	c.refs[ref.Name()] = ref
+	return appendString(dst, ref.Name())
+}
+
+func (c *bamCodec) Encode(dst []byte, r interfaces.Relatable) ([]byte, error) {
+	a, ok := r.(*Bam)
+	if !ok {
+		return dst, fmt.Errorf("parsers: can not encode %T as a Bam", r)
+	}
+	rec := a.Record
+	dst = appendString(dst, a.Chromosome)
+	dst = appendString(dst, rec.Name)
+	dst = c.appendRef(dst, rec.Ref)
+	dst = binary.AppendVarint(dst, int64(rec.Pos))
+	dst = append(dst, rec.MapQ)
+	dst = binary.AppendUvarint(dst, uint64(len(rec.Cigar)))
+	for _, op := range rec.Cigar {
+		dst = binary.AppendUvarint(dst, uint64(op))
+	}
+	dst = binary.AppendUvarint(dst, uint64(rec.Flags))
+	dst = c.appendRef(dst, rec.MateRef)
+	dst = binary.AppendVarint(dst, int64(rec.MatePos))
+	dst = binary.AppendVarint(dst, int64(rec.TempLen))
+	dst = binary.AppendUvarint(dst, uint64(rec.Seq.Length))
+	dst = binary.AppendUvarint(dst, uint64(len(rec.Seq.Seq)))
+	for _, d := range rec.Seq.Seq {
+		dst = append(dst, byte(d))
+	}
+	dst = appendBytes(dst, rec.Qual)
+	dst = binary.AppendUvarint(dst, uint64(len(rec.AuxFields)))
+	for _, aux := range rec.AuxFields {
+		dst = appendBytes(dst, aux)
+	}
+	return dst, nil
+}
+
+func (c *bamCodec) Decode(data []byte) (interfaces.Relatable, error) {
+	d := &decoder{data: data}
+	a := &Bam{Record: &sam.Record{}}
+	rec := a.Record
+	a.Chromosome = d.string()
+	rec.Name = d.string()
+	rec.Ref = c.refs[d.string()]
+	rec.Pos = int(d.varint())
+	rec.MapQ = d.byte()
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.data)) {
+		return nil, errShort
+	}
+	rec.Cigar = make(sam.Cigar, n)
+	for i := range rec.Cigar {
+		rec.Cigar[i] = sam.CigarOp(d.uvarint())
+	}
+	rec.Flags = sam.Flags(d.uvarint())
+	rec.MateRef = c.refs[d.string()]
+	rec.MatePos = int(d.varint())
+	rec.TempLen = int(d.varint())
+	rec.Seq.Length = int(d.uvarint())
+	n = d.uvarint()
+	if d.err != nil || n > uint64(len(d.data)) {
+		return nil, errShort
+	}
+	rec.Seq.Seq = make([]sam.Doublet, n)
+	for i := range rec.Seq.Seq {
+		rec.Seq.Seq[i] = sam.Doublet(d.byte())
+	}
+	rec.Qual = d.bytes()
+	n = d.uvarint()
+	if d.err != nil || n > uint64(len(d.data)) {
+		return nil, errShort
+	}
+	rec.AuxFields = make(sam.AuxFields, n)
+	for i := range rec.AuxFields {
+		rec.AuxFields[i] = sam.Aux(d.bytes())
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return a, nil
+}
+
 func check(err error) {
 	if err != nil {
 		panic(err)
@@ -71,6 +190,11 @@ func check(err error) {
 }
 
 func BamToRelatable(f io.Reader) (interfaces.RelatableChannel, error) {
+	return bamToRelatable(f, nil)
+}
+
+// bamToRelatable is BamToRelatable but stops reading once done is closed.
+func bamToRelatable(f io.Reader, done <-chan struct{}) (interfaces.RelatableChannel, error) {
 
 	ch := make(chan interfaces.Relatable, 64)
 	b, err := bam.NewReader(f, 0)
@@ -79,6 +203,7 @@ func BamToRelatable(f io.Reader) (interfaces.RelatableChannel, error) {
 	}
 
 	go func() {
+	read:
 		for {
 			rec, err := b.Read()
 			if err != nil {
@@ -94,11 +219,15 @@ func BamToRelatable(f io.Reader) (interfaces.RelatableChannel, error) {
 			}
 			// TODO: see if keeping the list of chrom names and using a ref is better.
 			bam := Bam{Record: rec, Chromosome: rec.Ref.Name(), related: nil}
-			ch <- &bam
+			select {
+			case ch <- &bam:
+			case <-done:
+				break read
+			}
 		}
-		close(ch)
 		b.Close()
 		f.(io.ReadCloser).Close()
+		close(ch)
 	}()
 	return ch, nil
 }
@@ -108,6 +237,8 @@ type BamQueryable struct {
 	path string
 	file io.Reader
 	refs map[string]*sam.Reference
+	// order holds the references as they appear in the header.
+	order []*sam.Reference
 }
 
 func NewBamQueryable(path string, workers ...int) (*BamQueryable, error) {
@@ -141,16 +272,35 @@ func NewBamQueryable(path string, workers ...int) (*BamQueryable, error) {
 	}
 	br.Close()
 
-	return &BamQueryable{idx: idx, path: path, file: b, refs: refs}, nil
+	return &BamQueryable{idx: idx, path: path, file: b, refs: refs, order: hdr.Refs()}, nil
 
 }
 
+// Names returns the reference names in the order of the @SQ lines in the header.
+func (b *BamQueryable) Names() []string {
+	names := make([]string, len(b.order))
+	for i, r := range b.order {
+		names[i] = r.Name()
+	}
+	return names
+}
+
+// Lengths returns the reference lengths in the order of the @SQ lines in the header.
+func (b *BamQueryable) Lengths() []int {
+	lengths := make([]int, len(b.order))
+	for i, r := range b.order {
+		lengths[i] = r.Len()
+	}
+	return lengths
+}
+
 // make a copy so we can mess with the file pointers.
 func newShort(old *BamQueryable) (*BamQueryable, error) {
 	b := &BamQueryable{
-		idx:  old.idx,
-		path: old.path,
-		refs: old.refs,
+		idx:   old.idx,
+		path:  old.path,
+		refs:  old.refs,
+		order: old.order,
 	}
 	var err error
 	b.file, err = os.Open(b.path)
@@ -163,25 +313,55 @@ func newShort(old *BamQueryable) (*BamQueryable, error) {
 	return b, nil
 }
 
+// ref returns the reference for chrom with or without the "chr" prefix.
+func (b *BamQueryable) ref(chrom string) (*sam.Reference, bool) {
+	ref, ok := b.refs[chrom]
+	if !ok {
+		if !strings.HasPrefix(chrom, "chr") {
+			ref, ok = b.refs["chr"+chrom]
+		} else {
+			ref, ok = b.refs[chrom[3:]]
+		}
+	}
+	return ref, ok
+}
+
+// Offsets returns the range of file offsets of the reads in chrom:start-end
+// from the BAI (see interfaces.Offsetter).
+func (b *BamQueryable) Offsets(chrom string, start, end int) (int64, int64, bool) {
+	ref, ok := b.ref(chrom)
+	if !ok {
+		return 0, 0, false
+	}
+	chunks, err := b.idx.Chunks(ref, start, end)
+	if err != nil || len(chunks) == 0 {
+		return 0, 0, false
+	}
+	return chunkOffsets(chunks)
+}
+
+// Size returns the size of the BAM file.
+func (b *BamQueryable) Size() int64 {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
 func (b *BamQueryable) Query(region interfaces.IPosition) (interfaces.RelatableIterator, error) {
 	bn, err := newShort(b) // make a copy since we're messing with the file-pointer
 	if err != nil {
 		return nil, err
 	}
 
-	ref, ok := b.refs[region.Chrom()]
-	if !ok {
-		if !strings.HasPrefix(region.Chrom(), "chr") {
-			ref, ok = b.refs["chr"+region.Chrom()]
-		} else if strings.HasPrefix(region.Chrom(), "chr") {
-			ref, ok = b.refs[region.Chrom()[3:]]
-		}
-	}
+	ref, ok := b.ref(region.Chrom())
 	if !ok {
 		return nil, fmt.Errorf("%s not found in %s", region.Chrom(), bn.path)
 	}
 
 	ch := make(chan interfaces.Relatable, 20)
+	done := make(chan struct{})
 	go func() {
 		brdr, err := bam.NewReader(bn.file, 1)
 		if err != nil {
@@ -210,6 +390,7 @@ func (b *BamQueryable) Query(region interfaces.IPosition) (interfaces.RelatableI
 			close(ch)
 			return
 		}
+	read:
 		for it.Next() {
 			rec := it.Record()
 			b := &Bam{Record: rec, Chromosome: chrom, related: nil}
@@ -217,15 +398,19 @@ func (b *BamQueryable) Query(region interfaces.IPosition) (interfaces.RelatableI
 				break
 			}
 			if b.End() > region.Start() {
-				ch <- b
+				select {
+				case ch <- b:
+				case <-done:
+					break read
+				}
 				//log.Printf("%s: %s:%d-%d is in %+v", b.Name, b.Chrom(), b.Start(), b.End(), region)
 				//log.Println(len(ch))
 			}
 		}
-		close(ch)
 		it.Close()
+		close(ch)
 	}()
-	return &BamIterator{ch, bn}, nil
+	return &BamIterator{ch: ch, b: bn, done: done}, nil
 }
 
 func (b *BamQueryable) Close() error {
@@ -238,6 +423,8 @@ func (b *BamQueryable) Close() error {
 type BamIterator struct {
 	ch interfaces.RelatableChannel
 	b  *BamQueryable
+	// done stops the goroutine that reads the BAM.
+	done chan struct{}
 }
 
 func NewBamIterator(f string) (*BamIterator, error) {
@@ -245,20 +432,38 @@ func NewBamIterator(f string) (*BamIterator, error) {
 	if err != nil {
 		return nil, err
 	}
-	ch, err := BamToRelatable(fh)
+	done := make(chan struct{})
+	ch, err := bamToRelatable(fh, done)
 
-	b := &BamIterator{ch: ch}
+	b := &BamIterator{ch: ch, done: done}
 
 	return b, err
 }
 
+// Close stops reading the BAM and waits until it is closed.
 func (b *BamIterator) Close() error {
+	if b.done != nil && b.ch != nil {
+		select {
+		case <-b.done:
+		default:
+			close(b.done)
+		}
+		// the reader closes ch once it has stopped.
+		for range b.ch {
+		}
+	}
 	if b.b != nil {
 		return b.b.Close()
 	}
 	return nil
 }
 
+// All returns the reads for use with range (see iterators.Seq). Stopping the
+// loop early closes b.
+func (b *BamIterator) All() iter.Seq2[interfaces.Relatable, error] {
+	return iterators.Seq(b)
+}
+
 func (b *BamIterator) Next() (interfaces.Relatable, error) {
 	rec, ok := <-b.ch
 	if !ok {
@@ -266,3 +471,5 @@ func (b *BamIterator) Next() (interfaces.Relatable, error) {
 	}
 	return rec, nil
 }
+
+var _ interfaces.EdgeRelatable = (*Bam)(nil)
@@ -0,0 +1,62 @@
+package parsers
+
+import "fmt"
+
+// CoordSystem is the coordinate convention of a file format. Whatever the
+// format, the intervals from this package use 0-based, half-open coordinates:
+// Start() is the first base and End() is one past the last base. An interval
+// with Start() == End() is zero-length; it is the point between bases
+// Start()-1 and Start() (e.g. an insertion site or a TSS given as a point).
+// See irelate.CheckRelatedByOverlapZeroLength for how such intervals are related.
+type CoordSystem int
+
+const (
+	// ZeroBasedHalfOpen is used by BED and BAM. A zero-length feature has
+	// end == start.
+	ZeroBasedHalfOpen CoordSystem = iota
+	// OneBasedClosed is used by VCF, GFF/GTF and SAM. A zero-length feature
+	// has end == start-1 (as for a GFF3 insertion site).
+	OneBasedClosed
+)
+
+// The coordinate system of each format read by this package.
+const (
+	// BedCoords is used by IntervalFromBedLine.
+	BedCoords = ZeroBasedHalfOpen
+	// GffCoords is used by IntervalFromGffLine.
+	GffCoords = OneBasedClosed
+	// VcfCoords is used by the VCF parsers. vcfgo converts POS; the End() of a
+	// variant is Start() plus the length of REF (or from the END field) so
+	// that an insertion covers its anchor base and is not zero-length.
+	VcfCoords = OneBasedClosed
+	// BamCoords is used by the BAM parsers. A read that consumes no reference
+	// bases is zero-length.
+	BamCoords = ZeroBasedHalfOpen
+)
+
+func (c CoordSystem) String() string {
+	switch c {
+	case ZeroBasedHalfOpen:
+		return "0-based, half-open"
+	case OneBasedClosed:
+		return "1-based, closed"
+	}
+	return fmt.Sprintf("CoordSystem(%d)", int(c))
+}
+
+// HalfOpen converts start and end as written in a file that uses c to the
+// 0-based, half-open coordinates used by this package. It returns an error if
+// the interval has a negative length or, for OneBasedClosed, if start is 0.
+func (c CoordSystem) HalfOpen(start, end uint64) (uint32, uint32, error) {
+	s := start
+	if c == OneBasedClosed {
+		if start == 0 {
+			return 0, 0, fmt.Errorf("start must be >= 1 in %s coordinates", c)
+		}
+		start--
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("negative length interval (start: %d, end: %d) in %s coordinates", s, end, c)
+	}
+	return uint32(start), uint32(end), nil
+}
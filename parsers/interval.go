@@ -2,6 +2,8 @@ package parsers
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -20,6 +22,9 @@ type Interval struct {
 	source  uint32
 	Fields  [][]byte
 	related []interfaces.Relatable
+	edges   []interfaces.Edge
+	// strand is set for formats where it is not in the 6th column.
+	strand byte
 }
 
 func NewInterval(chrom string, start uint32, end uint32, fields [][]byte, source uint32, related []interfaces.Relatable) *Interval {
@@ -34,9 +39,30 @@ func (i *Interval) AddRelated(b interfaces.Relatable) {
 	i.related = append(i.related, b)
 }
 
+// AddRelatedEdge adds e.Related to Related() and keeps e.
+func (i *Interval) AddRelatedEdge(e interfaces.Edge) {
+	i.related = append(i.related, e.Related)
+	i.edges = append(i.edges, e)
+}
+
+// RelatedEdges returns the edges in the same order as Related().
+func (i *Interval) RelatedEdges() []interfaces.Edge { return i.edges }
+
 func (i *Interval) Source() uint32       { return i.source }
 func (i *Interval) SetSource(src uint32) { i.source = src }
 
+// Strand returns the strand from the 6th column of a BED line or the 7th of a
+// GFF line. It is '.' if there is no strand.
+func (i *Interval) Strand() byte {
+	if i.strand != 0 {
+		return i.strand
+	}
+	if len(i.Fields) > 5 && len(i.Fields[5]) == 1 && (i.Fields[5][0] == '+' || i.Fields[5][0] == '-') {
+		return i.Fields[5][0]
+	}
+	return '.'
+}
+
 func (i *Interval) String() string {
 	return string(bytes.Join(i.Fields, []byte{'\t'}))
 }
@@ -56,7 +82,40 @@ func IntervalFromBedLine(line []byte) (interfaces.Relatable, error) {
 	if err != nil {
 		return nil, err
 	}
-	i := Interval{chrom: string(fields[0]), start: uint32(start), end: uint32(end), related: nil, Fields: fields}
+	s, e, err := BedCoords.HalfOpen(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, line)
+	}
+	i := Interval{chrom: string(fields[0]), start: s, end: e, related: nil, Fields: fields}
+	return &i, nil
+}
+
+// IntervalFromGffLine parses a GFF/GTF line. The 1-based, closed coordinates
+// are converted to the 0-based, half-open coordinates used by Interval so that
+// a zero-length feature (end == start-1) has Start() == End().
+func IntervalFromGffLine(line []byte) (interfaces.Relatable, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	fields := bytes.Split([]byte(string(line)), []byte{'\t'})
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("expected at least 7 columns in GFF line: %s", line)
+	}
+	start, err := strconv.ParseUint(unsafeString(fields[3]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	end, err := strconv.ParseUint(unsafeString(fields[4]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	s, e, err := GffCoords.HalfOpen(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("GFF %s: %s", err, line)
+	}
+	strand := byte('.')
+	if len(fields[6]) == 1 && (fields[6][0] == '+' || fields[6][0] == '-') {
+		strand = fields[6][0]
+	}
+	i := Interval{chrom: string(fields[0]), start: s, end: e, related: nil, Fields: fields, strand: strand}
 	return &i, nil
 }
 
@@ -80,11 +139,101 @@ func (i *RefAltInterval) Alt() []string {
 	return strings.Split(f, ",")
 }
 
+// End is Start() plus the length of the reference allele unless HasEnd is set.
+// An insertion written without an anchor base (REF of "-") is zero-length.
 func (i *RefAltInterval) End() uint32 {
 	if i.HasEnd {
 		return i.Interval.End()
 	}
-	return i.Start() + uint32(len(i.Ref()))
+	ref := i.Fields[i.refalt[0]]
+	if len(ref) == 1 && ref[0] == '-' {
+		return i.Start()
+	}
+	return i.Start() + uint32(len(ref))
+}
+
+// NewCodec returns a Codec for Intervals.
+func (i *Interval) NewCodec() (interfaces.Codec, error) {
+	return intervalCodec{}, nil
+}
+
+// NewCodec returns a Codec for RefAltIntervals.
+func (i *RefAltInterval) NewCodec() (interfaces.Codec, error) {
+	return refAltCodec{}, nil
+}
+
+type intervalCodec struct{}
+
+func (intervalCodec) Encode(dst []byte, r interfaces.Relatable) ([]byte, error) {
+	i, ok := r.(*Interval)
+	if !ok {
+		return dst, fmt.Errorf("parsers: can not encode %T as an Interval", r)
+	}
+	return i.appendTo(dst), nil
+}
+
+func (intervalCodec) Decode(data []byte) (interfaces.Relatable, error) {
+	d := &decoder{data: data}
+	i := &Interval{}
+	i.readFrom(d)
+	return i, d.err
+}
+
+type refAltCodec struct{}
+
+func (refAltCodec) Encode(dst []byte, r interfaces.Relatable) ([]byte, error) {
+	i, ok := r.(*RefAltInterval)
+	if !ok {
+		return dst, fmt.Errorf("parsers: can not encode %T as a RefAltInterval", r)
+	}
+	dst = i.Interval.appendTo(dst)
+	dst = binary.AppendUvarint(dst, uint64(i.refalt[0]))
+	dst = binary.AppendUvarint(dst, uint64(i.refalt[1]))
+	if i.HasEnd {
+		return append(dst, 1), nil
+	}
+	return append(dst, 0), nil
+}
+
+func (refAltCodec) Decode(data []byte) (interfaces.Relatable, error) {
+	d := &decoder{data: data}
+	i := &RefAltInterval{}
+	i.Interval.readFrom(d)
+	i.refalt[0] = int(d.uvarint())
+	i.refalt[1] = int(d.uvarint())
+	i.HasEnd = d.byte() == 1
+	return i, d.err
+}
+
+// appendTo appends the position, strand and fields of i to dst.
+func (i *Interval) appendTo(dst []byte) []byte {
+	dst = appendString(dst, i.chrom)
+	dst = binary.AppendUvarint(dst, uint64(i.start))
+	dst = binary.AppendUvarint(dst, uint64(i.end))
+	dst = append(dst, i.strand)
+	dst = binary.AppendUvarint(dst, uint64(len(i.Fields)))
+	for _, f := range i.Fields {
+		dst = appendBytes(dst, f)
+	}
+	return dst
+}
+
+func (i *Interval) readFrom(d *decoder) {
+	i.chrom = d.string()
+	i.start = uint32(d.uvarint())
+	i.end = uint32(d.uvarint())
+	i.strand = d.byte()
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.data)) {
+		d.err = errShort
+		return
+	}
+	i.Fields = make([][]byte, n)
+	for k := range i.Fields {
+		i.Fields[k] = d.bytes()
+	}
 }
 
 var _ interfaces.IRefAlt = (*RefAltInterval)(nil)
+var _ interfaces.Stranded = (*Interval)(nil)
+var _ interfaces.Encodable = (*Interval)(nil)
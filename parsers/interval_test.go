@@ -0,0 +1,102 @@
+package parsers_test
+
+import (
+	"github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+
+	. "gopkg.in/check.v1"
+)
+
+type IntervalSuite struct{}
+
+var _ = Suite(&IntervalSuite{})
+
+func (s *IntervalSuite) TestBedStrand(c *C) {
+	i, err := parsers.IntervalFromBedLine([]byte("chr1\t10\t20\tname\t0\t-\n"))
+	c.Assert(err, IsNil)
+	c.Assert(i.(interfaces.Stranded).Strand(), Equals, byte('-'))
+
+	i, err = parsers.IntervalFromBedLine([]byte("chr1\t10\t20\n"))
+	c.Assert(err, IsNil)
+	c.Assert(i.(interfaces.Stranded).Strand(), Equals, byte('.'))
+}
+
+func (s *IntervalSuite) TestGff(c *C) {
+	i, err := parsers.IntervalFromGffLine([]byte("chr1\tucb\tgene\t4\t8\t.\t+\t.\n"))
+	c.Assert(err, IsNil)
+	c.Assert(i.Chrom(), Equals, "chr1")
+	c.Assert(i.Start(), Equals, uint32(3))
+	c.Assert(i.End(), Equals, uint32(8))
+	c.Assert(i.(interfaces.Stranded).Strand(), Equals, byte('+'))
+
+	_, err = parsers.IntervalFromGffLine([]byte("chr1\tucb\tgene\t0\t8\t.\t+\t.\n"))
+	c.Assert(err, NotNil)
+	_, err = parsers.IntervalFromGffLine([]byte("chr1\t4\t8\n"))
+	c.Assert(err, NotNil)
+}
+
+func (s *IntervalSuite) TestIntervalCodec(c *C) {
+	i, err := parsers.IntervalFromGffLine([]byte("chr1\tsrc\tgene\t11\t20\t.\t-\t.\tID=a\n"))
+	c.Assert(err, IsNil)
+	codec, err := i.(interfaces.Encodable).NewCodec()
+	c.Assert(err, IsNil)
+	data, err := codec.Encode(nil, i)
+	c.Assert(err, IsNil)
+	d, err := codec.Decode(data)
+	c.Assert(err, IsNil)
+	c.Assert(d.Chrom(), Equals, "chr1")
+	c.Assert(d.Start(), Equals, uint32(10))
+	c.Assert(d.End(), Equals, uint32(20))
+	c.Assert(d.(interfaces.Stranded).Strand(), Equals, byte('-'))
+	c.Assert(d.(*parsers.Interval).String(), Equals, i.(*parsers.Interval).String())
+
+	_, err = codec.Decode(data[:5])
+	c.Assert(err, NotNil)
+}
+
+func (s *IntervalSuite) TestCoords(c *C) {
+	// the same base (the 11th) in each convention.
+	b, err := parsers.IntervalFromBedLine([]byte("chr1\t10\t11\n"))
+	c.Assert(err, IsNil)
+	g, err := parsers.IntervalFromGffLine([]byte("chr1\tsrc\tSNV\t11\t11\t.\t+\t.\n"))
+	c.Assert(err, IsNil)
+	c.Assert(g.Start(), Equals, b.Start())
+	c.Assert(g.End(), Equals, b.End())
+
+	// the same zero-length insertion site between the 10th and 11th bases.
+	b, err = parsers.IntervalFromBedLine([]byte("chr1\t10\t10\n"))
+	c.Assert(err, IsNil)
+	g, err = parsers.IntervalFromGffLine([]byte("chr1\tsrc\tinsertion_site\t11\t10\t.\t+\t.\n"))
+	c.Assert(err, IsNil)
+	c.Assert(b.Start(), Equals, uint32(10))
+	c.Assert(b.End(), Equals, uint32(10))
+	c.Assert(g.Start(), Equals, b.Start())
+	c.Assert(g.End(), Equals, b.End())
+
+	_, err = parsers.IntervalFromBedLine([]byte("chr1\t10\t9\n"))
+	c.Assert(err, NotNil)
+	_, err = parsers.IntervalFromGffLine([]byte("chr1\tsrc\tgene\t11\t9\t.\t+\t.\n"))
+	c.Assert(err, NotNil)
+
+	c.Assert(parsers.BedCoords, Equals, parsers.ZeroBasedHalfOpen)
+	c.Assert(parsers.BamCoords, Equals, parsers.ZeroBasedHalfOpen)
+	c.Assert(parsers.VcfCoords, Equals, parsers.OneBasedClosed)
+	c.Assert(parsers.GffCoords, Equals, parsers.OneBasedClosed)
+}
+
+func (s *IntervalSuite) TestRefAltEnd(c *C) {
+	ra := func(line string) *parsers.RefAltInterval {
+		i, err := parsers.IntervalFromBedLine([]byte(line))
+		c.Assert(err, IsNil)
+		r := &parsers.RefAltInterval{Interval: *i.(*parsers.Interval)}
+		r.SetRefAlt([]int{3, 4})
+		return r
+	}
+	// an insertion with an anchor base covers the anchor base.
+	c.Assert(ra("chr1\t9\t10\tA\tAT").End(), Equals, uint32(10))
+	c.Assert(ra("chr1\t9\t10\tACG\tA").End(), Equals, uint32(12))
+	// without an anchor base, it is zero-length.
+	r := ra("chr1\t10\t10\t-\tT")
+	c.Assert(r.Start(), Equals, uint32(10))
+	c.Assert(r.End(), Equals, uint32(10))
+}
@@ -0,0 +1,82 @@
+package parsers
+
+import (
+	"compress/gzip"
+	"os"
+	"strings"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/tabix"
+)
+
+// TabixIndex is the .tbi index of a bgzipped file. It implements
+// interfaces.Offsetter so that progress can be estimated from it.
+type TabixIndex struct {
+	idx  *tabix.Index
+	ids  map[string]int
+	size int64
+}
+
+// NewTabixIndex reads the index of path from path + ".tbi".
+func NewTabixIndex(path string) (*TabixIndex, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path + ".tbi")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	idx, err := tabix.ReadFrom(gz)
+	if err != nil {
+		return nil, err
+	}
+	return &TabixIndex{idx: idx, ids: idx.IDs(), size: fi.Size()}, nil
+}
+
+// Names returns the sequence names in the order of the index.
+func (t *TabixIndex) Names() []string {
+	return t.idx.Names()
+}
+
+// Offsets returns the range of file offsets of the records in chrom:start-end
+// (see interfaces.Offsetter).
+func (t *TabixIndex) Offsets(chrom string, start, end int) (int64, int64, bool) {
+	if _, ok := t.ids[chrom]; !ok {
+		if strings.HasPrefix(chrom, "chr") {
+			chrom = chrom[3:]
+		} else {
+			chrom = "chr" + chrom
+		}
+	}
+	if _, ok := t.ids[chrom]; !ok {
+		return 0, 0, false
+	}
+	chunks, err := t.idx.Chunks(chrom, start, end)
+	if err != nil || len(chunks) == 0 {
+		return 0, 0, false
+	}
+	return chunkOffsets(chunks)
+}
+
+// Size returns the size of the bgzipped file.
+func (t *TabixIndex) Size() int64 {
+	return t.size
+}
+
+// chunkOffsets returns the range of file offsets of chunks, which must not be
+// empty.
+func chunkOffsets(chunks []bgzf.Chunk) (int64, int64, bool) {
+	begin, end := chunks[0].Begin.File, chunks[0].End.File
+	for _, c := range chunks[1:] {
+		begin = min(begin, c.Begin.File)
+		end = max(end, c.End.File)
+	}
+	return begin, end, true
+}
@@ -1,16 +1,22 @@
 package parsers
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"iter"
 
 	"github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/iterators"
 	"github.com/brentp/vcfgo"
 )
 
+// Variant is a VCF record. Its coordinates are converted by vcfgo (see VcfCoords).
 type Variant struct {
 	interfaces.IVariant
 	source  uint32
 	related []interfaces.Relatable
+	edges   []interfaces.Edge
 }
 
 func (v *Variant) String() string {
@@ -18,7 +24,7 @@ func (v *Variant) String() string {
 }
 
 func NewVariant(v interfaces.IVariant, source uint32, related []interfaces.Relatable) *Variant {
-	return &Variant{v, source, related}
+	return &Variant{IVariant: v, source: source, related: related}
 }
 
 func (v *Variant) AddRelated(r interfaces.Relatable) {
@@ -32,9 +38,63 @@ func (v *Variant) Related() []interfaces.Relatable {
 	return v.related
 }
 
+// AddRelatedEdge adds e.Related to Related() and keeps e.
+func (v *Variant) AddRelatedEdge(e interfaces.Edge) {
+	v.AddRelated(e.Related)
+	v.edges = append(v.edges, e)
+}
+
+// RelatedEdges returns the edges in the same order as Related().
+func (v *Variant) RelatedEdges() []interfaces.Edge {
+	return v.edges
+}
+
 func (v *Variant) SetSource(src uint32) { v.source = src }
 func (v *Variant) Source() uint32       { return v.source }
 
+// NewCodec returns a Codec for Variants that have the same header as v.
+// Variants are encoded as VCF lines, so v must be from a vcfgo.Reader.
+func (v *Variant) NewCodec() (interfaces.Codec, error) {
+	vv, ok := v.IVariant.(*vcfgo.Variant)
+	if !ok || vv.Header == nil {
+		return nil, fmt.Errorf("parsers: can not encode a Variant without a VCF header")
+	}
+	return &variantCodec{hdr: vv.Header}, nil
+}
+
+type variantCodec struct {
+	hdr *vcfgo.Header
+	// lines holds the line being decoded; rdr reads all of them.
+	lines bytes.Buffer
+	rdr   *vcfgo.Reader
+}
+
+func (c *variantCodec) Encode(dst []byte, r interfaces.Relatable) ([]byte, error) {
+	v, ok := r.(*Variant)
+	if !ok {
+		return dst, fmt.Errorf("parsers: can not encode %T as a Variant", r)
+	}
+	dst = append(dst, v.String()...)
+	return append(dst, '\n'), nil
+}
+
+func (c *variantCodec) Decode(data []byte) (interfaces.Relatable, error) {
+	c.lines.Write(data)
+	if c.rdr == nil {
+		rdr, err := vcfgo.NewWithHeader(&c.lines, c.hdr, true)
+		if err != nil {
+			return nil, err
+		}
+		c.rdr = rdr
+	}
+	v := c.rdr.Read()
+	c.rdr.Clear()
+	if v == nil {
+		return nil, fmt.Errorf("parsers: unable to decode variant: %s", bytes.TrimSpace(data))
+	}
+	return &Variant{IVariant: v}, nil
+}
+
 func Vopen(rdr io.Reader, hdr *vcfgo.Header) (*vcfgo.Reader, error) {
 	if hdr == nil {
 		return vcfgo.NewReader(rdr, true)
@@ -51,7 +111,7 @@ func StreamVCF(vcf *vcfgo.Reader) interfaces.RelatableChannel {
 			if v == nil {
 				break
 			}
-			ch <- &Variant{v, 0, nil}
+			ch <- &Variant{IVariant: v}
 			j++
 			if j < 1000 {
 				vcf.Clear()
@@ -72,7 +132,7 @@ func (v vWrapper) Next() (interfaces.Relatable, error) {
 	if r == nil {
 		return nil, io.EOF
 	}
-	return &Variant{r, 0, nil}, nil
+	return &Variant{IVariant: r}, nil
 }
 
 func (v vWrapper) AddInfoToHeader(id, itype, number, description string) {
@@ -86,3 +146,13 @@ func VCFIterator(buf io.Reader) (interfaces.RelatableIterator, *vcfgo.Reader, er
 	}
 	return vWrapper{v}, v, nil
 }
+
+// VCFSeq is VCFIterator for use with range (see iterators.Seq). The reader is
+// returned so that the header can be used.
+func VCFSeq(buf io.Reader) (iter.Seq2[interfaces.Relatable, error], *vcfgo.Reader, error) {
+	it, v, err := VCFIterator(buf)
+	if err != nil {
+		return nil, v, err
+	}
+	return iterators.Seq(it), v, nil
+}
@@ -0,0 +1,95 @@
+package irelate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// Progress is an Observer that estimates how much of a job is done from the
+// index of one of its files, usually the query (e.g. a parsers.BamQueryable
+// or parsers.TabixIndex). The fraction done is the offset in that file of the
+// end of the chunks that have been swept (or, for IRelate, of the start of
+// the chromosome the sweep has reached) over the size of the file. With
+// Unordered, a chunk may be done before those that precede it so the
+// estimate can run ahead.
+type Progress struct {
+	NopObserver
+	idx   Offsetter
+	size  int64
+	start time.Time
+
+	mu   sync.Mutex
+	done int64
+}
+
+// NewProgress returns a Progress for the file with index idx. The time left
+// is estimated from the time since it was created.
+func NewProgress(idx Offsetter) *Progress {
+	return &Progress{idx: idx, size: idx.Size(), start: time.Now()}
+}
+
+func (p *Progress) advance(offset int64) {
+	p.mu.Lock()
+	if offset > p.done {
+		p.done = offset
+	}
+	p.mu.Unlock()
+}
+
+func (p *Progress) ChunkDone(c ChunkInfo, _ ChunkTimings) {
+	if _, end, ok := p.idx.Offsets(c.Chrom, c.Start, c.End); ok {
+		p.advance(end)
+	}
+}
+
+func (p *Progress) Chrom(prev, next string) {
+	if begin, _, ok := p.idx.Offsets(next, 0, wholeChrom); ok {
+		p.advance(begin)
+	}
+}
+
+// Estimate returns the fraction of the job that is done and the time left.
+// left is -1 until something is done.
+func (p *Progress) Estimate() (done float64, left time.Duration) {
+	p.mu.Lock()
+	offset := p.done
+	p.mu.Unlock()
+	if p.size <= 0 || offset <= 0 {
+		return 0, -1
+	}
+	done = float64(offset) / float64(p.size)
+	if done > 1 {
+		done = 1
+	}
+	elapsed := time.Since(p.start)
+	return done, time.Duration(float64(elapsed) * (1 - done) / done)
+}
+
+// String gives the percent done and the time left.
+func (p *Progress) String() string {
+	done, left := p.Estimate()
+	if left < 0 {
+		return "0.0% done"
+	}
+	return fmt.Sprintf("%.1f%% done, %s left", 100*done, left.Round(time.Second))
+}
+
+// Report writes the progress to w each time every elapses until ctx is done. It is
+// usually run in its own goroutine.
+func (p *Progress) Report(ctx context.Context, w io.Writer, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			fmt.Fprintln(w, p)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
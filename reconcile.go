@@ -0,0 +1,447 @@
+package irelate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+)
+
+// wholeChrom is the end used to query an entire chromosome. It is the largest
+// position that a BAI or tabix index can hold.
+const wholeChrom = 1<<29 - 1
+
+// ChromOrders returns the order of the chromosomes in each Queryable. Each must
+// implement interfaces.Namer (as parsers.BamQueryable does; see IRelateFiles
+// for tabix-indexed files); an error is returned for one that does not.
+func ChromOrders(qs ...Queryable) ([][]string, error) {
+	orders := make([][]string, len(qs))
+	for i, q := range qs {
+		n, ok := q.(Namer)
+		if !ok {
+			return nil, fmt.Errorf("irelate: can't get chromosome names for source: %d", i)
+		}
+		orders[i] = n.Names()
+	}
+	return orders, nil
+}
+
+// ReconcileOrders returns a ChromOrder that agrees with every one of orders.
+// Chromosomes are compared without a "chr" prefix. If 2 orders disagree, an
+// *ErrChromOrder with a diff of the orders is returned.
+func ReconcileOrders(orders ...[]string) (*ChromOrder, error) {
+	uniq := make([][]string, len(orders))
+	for i, order := range orders {
+		uniq[i] = uniqueChroms(order)
+	}
+	orders = uniq
+	for i := 0; i < len(orders); i++ {
+		for j := i + 1; j < len(orders); j++ {
+			if chrom, ok := agree(orders[i], orders[j]); !ok {
+				diff := fmt.Sprintf("--- source %d\n+++ source %d\n%s", i, j, diffOrders(orders[i], orders[j]))
+				return nil, &ErrChromOrder{Source: j, Chrom: chrom, Diff: diff}
+			}
+		}
+	}
+	return mergeOrders(orders)
+}
+
+// agree returns true if the chromosomes shared by a and b are in the same
+// order. If not, it returns the first shared chromosome in a that is out of place.
+func agree(a, b []string) (string, bool) {
+	// a header may name the same chromosome with and without "chr".
+	a, b = uniqueChroms(a), uniqueChroms(b)
+	inA, inB := chromSet(a), chromSet(b)
+	k := 0
+	for _, c := range a {
+		if !inB[StripChr(c)] {
+			continue
+		}
+		for k < len(b) && !inA[StripChr(b[k])] {
+			k++
+		}
+		if k == len(b) || StripChr(b[k]) != StripChr(c) {
+			return c, false
+		}
+		k++
+	}
+	return "", true
+}
+
+// uniqueChroms returns names without those that are the same as an earlier
+// one after StripChr.
+func uniqueChroms(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if !seen[StripChr(n)] {
+			seen[StripChr(n)] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func chromSet(names []string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[StripChr(n)] = true
+	}
+	return m
+}
+
+// mergeOrders does a topological sort of the chromosomes so that each order is
+// kept. Ties are broken by the first appearance in orders.
+func mergeOrders(orders [][]string) (*ChromOrder, error) {
+	rank := make(map[string]int)
+	var names []string
+	after := make(map[string][]string)
+	indegree := make(map[string]int)
+	for _, order := range orders {
+		for i, c := range order {
+			s := StripChr(c)
+			if _, ok := rank[s]; !ok {
+				rank[s] = len(names)
+				names = append(names, c)
+				indegree[s] = 0
+			}
+			if i > 0 {
+				p := StripChr(order[i-1])
+				after[p] = append(after[p], s)
+				indegree[s]++
+			}
+		}
+	}
+	merged := make([]string, 0, len(names))
+	used := make([]bool, len(names))
+	for len(merged) < len(names) {
+		next := -1
+		for i, c := range names {
+			if !used[i] && indegree[StripChr(c)] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			for i, c := range names {
+				if !used[i] {
+					return nil, &ErrChromOrder{Source: -1, Chrom: c, Diff: "no single chromosome order agrees with all sources\n"}
+				}
+			}
+		}
+		used[next] = true
+		merged = append(merged, names[next])
+		for _, s := range after[StripChr(names[next])] {
+			indegree[s]--
+		}
+	}
+	return NewChromOrder(merged, nil), nil
+}
+
+// diffOrders shows the differences between 2 chromosome orders. chromosomes
+// only in a start with '-', those only in b with '+'.
+func diffOrders(a, b []string) string {
+	// longest common subsequence.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if StripChr(a[i]) == StripChr(b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var buf bytes.Buffer
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && StripChr(a[i]) == StripChr(b[j]):
+			fmt.Fprintf(&buf, " %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&buf, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&buf, "+%s\n", b[j])
+			j++
+		}
+	}
+	return buf.String()
+}
+
+// OrderMode determines what IRelateQueryables does when the chromosome orders
+// of the sources disagree.
+type OrderMode int
+
+const (
+	// OrderStrict returns the *ErrChromOrder from ReconcileOrders.
+	OrderStrict OrderMode = iota
+	// OrderByChrom relates one chromosome at a time (see IRelateByChrom).
+	OrderByChrom
+)
+
+// IRelateQueryables checks the chromosome order of each Queryable before relating
+// them. If the orders agree, the Queryables are streamed in the reconciled order
+// to a single IRelate. If they do not, mode determines whether an error is
+// returned or IRelateByChrom is used. Each Queryable must implement
+// interfaces.Namer.
+func IRelateQueryables(mode OrderMode, checkRelated func(a, b Relatable) bool, relativeTo int, qs ...Queryable) (RelatableIterator, error) {
+	if relativeTo >= len(qs) {
+		return nil, errRelativeTo(relativeTo, len(qs))
+	}
+	orders, err := ChromOrders(qs...)
+	if err != nil {
+		return nil, err
+	}
+	order, err := ReconcileOrders(orders...)
+	if err != nil {
+		if _, ok := err.(*ErrChromOrder); ok && mode == OrderByChrom {
+			return IRelateByChrom(checkRelated, relativeTo, qs...)
+		}
+		return nil, err
+	}
+	streams := make([]RelatableIterator, len(qs))
+	for i, q := range qs {
+		streams[i] = &chromStream{q: q, chroms: order.Names(), names: chromNames(orders[i])}
+	}
+	return IRelate(checkRelated, relativeTo, order.Less, streams...), nil
+}
+
+// IRelateByChrom relates the Queryables one chromosome at a time, calling Query
+// for each chromosome on each of them so that the chromosome order within each
+// file does not matter. Output is in the chromosome order of the query source
+// (or all sources when relativeTo < 0). Each Queryable must implement
+// interfaces.Namer.
+func IRelateByChrom(checkRelated func(a, b Relatable) bool, relativeTo int, qs ...Queryable) (RelatableIterator, error) {
+	orders, err := ChromOrders(qs...)
+	if err != nil {
+		return nil, err
+	}
+	if relativeTo >= len(qs) {
+		return nil, errRelativeTo(relativeTo, len(qs))
+	}
+	var chroms []string
+	if relativeTo >= 0 {
+		chroms = uniqueChroms(orders[relativeTo])
+	} else {
+		seen := make(map[string]bool)
+		for _, order := range orders {
+			for _, c := range order {
+				if !seen[StripChr(c)] {
+					seen[StripChr(c)] = true
+					chroms = append(chroms, c)
+				}
+			}
+		}
+	}
+	names := make([]map[string]string, len(qs))
+	for i := range orders {
+		names[i] = chromNames(orders[i])
+	}
+	return &byChrom{checkRelated: checkRelated, relativeTo: relativeTo, chroms: chroms, qs: qs, names: names}, nil
+}
+
+// IRelateFiles opens the bgzipped, tabix-indexed files in paths with
+// AsQueryable and relates them with IRelateQueryables, taking the chromosome
+// names of each file from its .tbi. If an index can not be read, each file is
+// instead streamed whole with Query(nil) to IRelate with LessPrefix, so the
+// files must then be sorted in the same chromosome order. Closing the returned
+// iterator closes the files.
+func IRelateFiles(mode OrderMode, checkRelated func(a, b Relatable) bool, relativeTo int, paths ...string) (RelatableIterator, error) {
+	qs := make([]Queryable, 0, len(paths))
+	named := true
+	for _, p := range paths {
+		q, err := AsQueryable(p)
+		if err != nil {
+			closeQueryables(qs)
+			return nil, err
+		}
+		if named {
+			if idx, err := parsers.NewTabixIndex(p); err == nil {
+				q = tabixNames{q, idx}
+			} else {
+				named = false
+			}
+		}
+		qs = append(qs, q)
+	}
+	var it RelatableIterator
+	var err error
+	if named {
+		it, err = IRelateQueryables(mode, checkRelated, relativeTo, qs...)
+	} else {
+		it, err = iRelateWhole(checkRelated, relativeTo, qs)
+	}
+	if err != nil {
+		closeQueryables(qs)
+		return nil, err
+	}
+	return &filesIterator{it, qs}, nil
+}
+
+// iRelateWhole relates all of the intervals in each of qs without reconciling
+// their chromosome orders.
+func iRelateWhole(checkRelated func(a, b Relatable) bool, relativeTo int, qs []Queryable) (RelatableIterator, error) {
+	streams := make([]RelatableIterator, len(qs))
+	for i, q := range qs {
+		s, err := q.Query(nil)
+		if err != nil {
+			closeAll(streams[:i])
+			return nil, &ErrSource{Source: uint32(i), Err: err}
+		}
+		streams[i] = s
+	}
+	return IRelate(checkRelated, relativeTo, LessPrefix, streams...), nil
+}
+
+// tabixNames is a Queryable for a tabix-indexed file with the names from its
+// index.
+type tabixNames struct {
+	Queryable
+	*parsers.TabixIndex
+}
+
+// filesIterator closes the files opened by IRelateFiles when it is closed.
+type filesIterator struct {
+	RelatableIterator
+	qs []Queryable
+}
+
+func (f *filesIterator) Close() error {
+	err := f.RelatableIterator.Close()
+	if e := closeQueryables(f.qs); err == nil {
+		err = e
+	}
+	return err
+}
+
+// closeQueryables closes each of qs that is an io.Closer.
+func closeQueryables(qs []Queryable) error {
+	var err error
+	for _, q := range qs {
+		if t, ok := q.(tabixNames); ok {
+			q = t.Queryable
+		}
+		if c, ok := q.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func errRelativeTo(relativeTo, n int) error {
+	return fmt.Errorf("irelate: relativeTo is %d but there are only %d sources", relativeTo, n)
+}
+
+type byChrom struct {
+	checkRelated func(a, b Relatable) bool
+	relativeTo   int
+	chroms       []string
+	qs           []Queryable
+	names        []map[string]string
+	it           RelatableIterator
+}
+
+func (b *byChrom) Next() (Relatable, error) {
+	for {
+		if b.it == nil {
+			if len(b.chroms) == 0 {
+				return nil, io.EOF
+			}
+			chrom := b.chroms[0]
+			b.chroms = b.chroms[1:]
+			streams := make([]RelatableIterator, len(b.qs))
+			for i, q := range b.qs {
+				s, err := queryChrom(q, chrom, b.names[i])
+				if err != nil {
+					closeAll(streams[:i])
+					return nil, &ErrSource{Source: uint32(i), Err: err}
+				}
+				streams[i] = s
+			}
+			b.it = IRelate(b.checkRelated, b.relativeTo, less, streams...)
+		}
+		v, err := b.it.Next()
+		if err == io.EOF {
+			b.it.Close()
+			b.it = nil
+			continue
+		}
+		return v, err
+	}
+}
+
+func (b *byChrom) Close() error {
+	if b.it != nil {
+		return b.it.Close()
+	}
+	return nil
+}
+
+// queryChrom queries all of chrom by its name in q (see chromNames) or returns
+// an empty stream if q does not have it.
+func queryChrom(q Queryable, chrom string, names map[string]string) (RelatableIterator, error) {
+	name, ok := names[StripChr(chrom)]
+	if !ok {
+		return sliceToIterator(nil), nil
+	}
+	return q.Query(pos{name, 0, wholeChrom})
+}
+
+// chromNames maps each chromosome in order without a "chr" prefix to its name
+// in order so that a file is queried with its own names.
+func chromNames(order []string) map[string]string {
+	m := make(map[string]string, len(order))
+	for _, n := range order {
+		if _, ok := m[StripChr(n)]; !ok {
+			m[StripChr(n)] = n
+		}
+	}
+	return m
+}
+
+// chromStream streams all intervals from a Queryable in the order of chroms.
+type chromStream struct {
+	q      Queryable
+	chroms []string
+	names  map[string]string
+	it     RelatableIterator
+}
+
+func (c *chromStream) Next() (Relatable, error) {
+	for {
+		if c.it == nil {
+			if len(c.chroms) == 0 {
+				return nil, io.EOF
+			}
+			it, err := queryChrom(c.q, c.chroms[0], c.names)
+			if err != nil {
+				return nil, err
+			}
+			c.it, c.chroms = it, c.chroms[1:]
+		}
+		v, err := c.it.Next()
+		if err == io.EOF {
+			c.it.Close()
+			c.it = nil
+			continue
+		}
+		return v, err
+	}
+}
+
+func (c *chromStream) Close() error {
+	if c.it != nil {
+		return c.it.Close()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package irelate
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+type namedQueryable struct {
+	sliceQueryable
+	names []string
+}
+
+func (n namedQueryable) Names() []string { return n.names }
+
+// Query finds only the chromosomes named as in names, as an index does.
+func (n namedQueryable) Query(p IPosition) (RelatableIterator, error) {
+	for _, c := range n.names {
+		if c == p.Chrom() {
+			return n.sliceQueryable.Query(p)
+		}
+	}
+	return sliceToIterator(nil), nil
+}
+
+func named(names []string, rels ...Relatable) namedQueryable {
+	return namedQueryable{newSliceQueryable(rels), names}
+}
+
+func TestReconcileOrders(t *testing.T) {
+	o, err := ReconcileOrders([]string{"1", "2", "X"}, []string{"chr1", "chr2", "chrM", "chrX", "chrY"}, []string{"2", "GL1", "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(o.Names(), ","); got != "1,2,chrM,GL1,X,chrY" {
+		t.Errorf("unexpected order: %s", got)
+	}
+
+	_, err = ReconcileOrders([]string{"1", "2", "X", "Y", "MT"}, []string{"1", "2", "MT", "X", "Y"})
+	e, ok := err.(*ErrChromOrder)
+	if !ok {
+		t.Fatalf("expected *ErrChromOrder, got %v", err)
+	}
+	if e.Source != 1 || e.Chrom != "X" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+	if !strings.Contains(e.Diff, "+MT\n X\n Y\n-MT\n") {
+		t.Errorf("unexpected diff:\n%s", e.Diff)
+	}
+
+	// the same chromosome with and without "chr" in one header.
+	o, err = ReconcileOrders([]string{"1", "chr1", "2", "chr2"}, []string{"1", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(o.Names(), ","); got != "1,2" {
+		t.Errorf("unexpected order: %s", got)
+	}
+	if c, ok := agree([]string{"1", "chr1", "2"}, []string{"2", "1"}); ok || c != "1" {
+		t.Errorf("expected 1 to be out of order, got %q, %v", c, ok)
+	}
+}
+
+func countRelated(t *testing.T, it RelatableIterator) []string {
+	var out []string
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, r.Chrom()+":"+string(rune('0'+len(r.Related()))))
+	}
+	return out
+}
+
+func TestIRelateQueryables(t *testing.T) {
+	a := named([]string{"1", "2", "X", "MT"}, iv("1", 10, 20), iv("X", 10, 20), iv("MT", 10, 20))
+	b := named([]string{"1", "2", "MT", "X"}, iv("1", 15, 25), iv("MT", 1, 12), iv("X", 5, 15), iv("X", 12, 15))
+
+	if _, err := IRelateQueryables(OrderStrict, CheckRelatedByOverlap, 0, a, b); err == nil {
+		t.Fatal("expected an error for different chromosome orders")
+	}
+	it, err := IRelateQueryables(OrderByChrom, CheckRelatedByOverlap, 0, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(countRelated(t, it), ","); got != "1:1,X:2,MT:1" {
+		t.Errorf("unexpected relations: %s", got)
+	}
+
+	c := named([]string{"1", "X", "MT"}, iv("1", 12, 13), iv("MT", 0, 30))
+	it, err = IRelateQueryables(OrderStrict, CheckRelatedByOverlap, 0, a, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(countRelated(t, it), ","); got != "1:1,X:0,MT:1" {
+		t.Errorf("unexpected relations: %s", got)
+	}
+
+	if _, err := IRelateQueryables(OrderStrict, CheckRelatedByOverlap, 2, a, c); err == nil {
+		t.Error("expected an error for relativeTo > the number of sources")
+	}
+	if _, err := IRelateByChrom(CheckRelatedByOverlap, 2, a, c); err == nil {
+		t.Error("expected an error for relativeTo > the number of sources")
+	}
+}
+
+func TestIRelateQueryablesChrPrefix(t *testing.T) {
+	a := named([]string{"chr1", "chr2", "chrX"}, iv("chr1", 10, 20), iv("chr2", 10, 20), iv("chrX", 10, 20))
+	b := named([]string{"1", "2", "X"}, iv("1", 15, 25), iv("2", 1, 12), iv("X", 5, 15), iv("X", 12, 15))
+	c := named([]string{"X", "1", "2"}, iv("X", 15, 25), iv("1", 1, 12), iv("2", 5, 15))
+	for _, tc := range []struct {
+		mode     OrderMode
+		db       namedQueryable
+		expected string
+	}{
+		{OrderStrict, b, "chr1:1,chr2:1,chrX:2"},
+		{OrderByChrom, c, "chr1:1,chr2:1,chrX:1"},
+	} {
+		it, err := IRelateQueryables(tc.mode, CheckOverlapPrefix, 0, a, tc.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(countRelated(t, it), ","); got != tc.expected {
+			t.Errorf("mode %d: expected %s, got %s", tc.mode, tc.expected, got)
+		}
+	}
+}
+
+func TestMergerChromOrder(t *testing.T) {
+	a := ivs(iv("1", 10, 20), iv("X", 10, 20), iv("MT", 10, 20))
+	b := ivs(iv("1", 15, 25), iv("MT", 1, 12), iv("X", 5, 15))
+	it := IRelate(CheckRelatedByOverlap, 0, NewChromOrder([]string{"1", "X", "MT"}, nil).Less, a, b)
+	var err error
+	for err == nil {
+		_, err = it.Next()
+	}
+	if e, ok := err.(*ErrChromOrder); !ok || e.Chrom != "X" {
+		t.Errorf("expected *ErrChromOrder for X, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package irelate
+
+import (
+	"context"
+	"iter"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/iterators"
+)
+
+// IRelateSeq is IRelate for use with range:
+//
+//	for r, err := range IRelateSeq(CheckRelatedByOverlap, 0, Less, query, db) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The streams are closed when the loop ends, including when it is stopped
+// early by break or return. An error is sent once, with a nil interval.
+func IRelateSeq(checkRelated func(a, b Relatable) bool,
+	relativeTo int,
+	less func(a, b Relatable) bool,
+	streams ...RelatableIterator) iter.Seq2[Relatable, error] {
+	return iterators.Seq(IRelate(checkRelated, relativeTo, less, streams...))
+}
+
+// PIRelateSeq is PIRelateWithOptions for use with range. The goroutines are
+// started when the loop starts. When the loop ends, including when it is stopped
+// early by break or return, they are stopped and all open streams are closed
+// before the loop returns. An error (including ctx.Err() if ctx is done before
+// the last interval) is sent once, with a nil interval.
+func PIRelateSeq(ctx context.Context, qstream RelatableIterator, opts *PIRelateOptions, dbs ...Queryable) iter.Seq2[Relatable, error] {
+	return func(yield func(Relatable, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		st := newPState(ctx, false)
+		ch := pirelate(st, qstream, opts, dbs...)
+		defer func() {
+			cancel()
+			for range ch {
+			}
+			st.wg.Wait()
+		}()
+		for r := range ch {
+			if !yield(r, nil) {
+				return
+			}
+		}
+		if err := st.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package irelate
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIRelateSeq(t *testing.T) {
+	open := new(int32)
+	*open = 2
+	a := &countIt{RelatableIterator: ivs(spaced("chr1", 100, 20, 5)...), open: open}
+	b := &countIt{RelatableIterator: ivs(spaced("chr1", 100, 10, 15)...), open: open}
+	n := 0
+	for r, err := range IRelateSeq(CheckRelatedByOverlap, 0, Less, a, b) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Related()) == 0 {
+			t.Errorf("expected overlap for %s:%d-%d", r.Chrom(), r.Start(), r.End())
+		}
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if *open != 0 {
+		t.Errorf("expected streams to be closed after break, %d open", *open)
+	}
+}
+
+func TestPIRelateSeq(t *testing.T) {
+	db := newSliceQueryable(spaced("chr1", 2000, 10, 15))
+	n := 0
+	for r, err := range PIRelateSeq(context.Background(), ivs(spaced("chr1", 1000, 20, 5)...), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000}, db) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Related()) == 0 {
+			t.Errorf("expected overlap for %s:%d-%d", r.Chrom(), r.Start(), r.End())
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("expected 1000 intervals, got %d", n)
+	}
+
+	base := runtime.NumGoroutine()
+	db = newSliceQueryable(spaced("chr1", 200000, 10, 15))
+	n = 0
+	for _, err := range PIRelateSeq(context.Background(), ivs(spaced("chr1", 100000, 20, 5)...), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000}, db) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	// the streams are closed before the loop returns.
+	if o := atomic.LoadInt32(db.open); o != 0 {
+		t.Errorf("expected all streams to be closed, %d open", o)
+	}
+	// goroutines that have called wg.Done may not have exited yet.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g > base {
+		t.Errorf("goroutines leaked: %d > %d", g, base)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n = 0
+	var last error
+	for _, err := range PIRelateSeq(ctx, ivs(spaced("chr1", 100000, 20, 5)...), &PIRelateOptions{ChunkSize: 100, MaxGap: 1000}, db) {
+		if err != nil {
+			last = err
+			continue
+		}
+		n++
+		if n == 10 {
+			cancel()
+		}
+	}
+	if last != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", last)
+	}
+}
@@ -0,0 +1,101 @@
+package irelate
+
+import (
+	"context"
+	"reflect"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// Relation is a query interval with its related intervals from each database.
+type Relation[Q, D Relatable] struct {
+	Query Q
+	// Related holds the intervals related to Query by database: Related[i] is
+	// from the i'th database (source i+1).
+	Related [][]D
+}
+
+// Relations iterates over the Relations from Relate.
+type Relations[Q, D Relatable] struct {
+	it  RelatableIterator
+	ndb int
+	// err is returned from all calls to Next() after it is set.
+	err error
+}
+
+// Relate is IRelate with query as source 0 and dbs as sources 1, 2, ...
+// Next() returns the query intervals as type Q with their related intervals
+// as type D. When the databases hold different types, use Relatable as D and
+// RelatedAs to get each database as its own type.
+func Relate[Q, D Relatable](checkRelated func(a, b Relatable) bool,
+	less func(a, b Relatable) bool,
+	query RelatableIterator, dbs ...RelatableIterator) *Relations[Q, D] {
+	return RelateContext[Q, D](context.Background(), checkRelated, less, query, dbs...)
+}
+
+// RelateContext is the same as Relate but stops when ctx is done (see IRelateContext).
+func RelateContext[Q, D Relatable](ctx context.Context,
+	checkRelated func(a, b Relatable) bool,
+	less func(a, b Relatable) bool,
+	query RelatableIterator, dbs ...RelatableIterator) *Relations[Q, D] {
+	streams := append([]RelatableIterator{query}, dbs...)
+	return &Relations[Q, D]{it: IRelateContext(ctx, checkRelated, 0, less, streams...), ndb: len(dbs)}
+}
+
+// Next returns the next query interval and its related intervals. If an
+// interval is not of the expected type, it returns an *ErrType and closes the
+// streams.
+func (r *Relations[Q, D]) Next() (Relation[Q, D], error) {
+	var rel Relation[Q, D]
+	if r.err != nil {
+		return rel, r.err
+	}
+	v, err := r.it.Next()
+	if err != nil {
+		return rel, err
+	}
+	q, ok := v.(Q)
+	if !ok {
+		return rel, r.fail(v, typeName[Q]())
+	}
+	rel.Query = q
+	rel.Related = make([][]D, r.ndb)
+	for _, b := range v.Related() {
+		d, ok := b.(D)
+		if !ok {
+			return Relation[Q, D]{}, r.fail(b, typeName[D]())
+		}
+		i := b.Source() - 1
+		rel.Related[i] = append(rel.Related[i], d)
+	}
+	return rel, nil
+}
+
+func (r *Relations[Q, D]) fail(v Relatable, want string) error {
+	r.err = &ErrType{Source: v.Source(), Want: want, Got: reflect.TypeOf(v).String()}
+	r.it.Close()
+	return r.err
+}
+
+// Close closes any of the streams that have not been exhausted.
+func (r *Relations[Q, D]) Close() error {
+	return r.it.Close()
+}
+
+// RelatedAs returns the intervals related to r.Query from the i'th database as
+// type T or an *ErrType if any of them is not a T.
+func RelatedAs[T Relatable, Q, D Relatable](r Relation[Q, D], i int) ([]T, error) {
+	out := make([]T, 0, len(r.Related[i]))
+	for _, b := range r.Related[i] {
+		t, ok := Relatable(b).(T)
+		if !ok {
+			return nil, &ErrType{Source: b.Source(), Want: typeName[T](), Got: reflect.TypeOf(b).String()}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func typeName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
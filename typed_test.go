@@ -0,0 +1,58 @@
+package irelate
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+	"github.com/brentp/irelate/parsers"
+)
+
+func TestRelate(t *testing.T) {
+	rels := Relate[*parsers.Interval, *parsers.Interval](CheckRelatedByOverlap, Less,
+		ivs(iv("chr1", 10, 20), iv("chr1", 30, 40)),
+		ivs(iv("chr1", 15, 35)), ivs(iv("chr1", 0, 12), iv("chr1", 38, 50)))
+	var got [][]int
+	for {
+		r, err := rels.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, []int{int(r.Query.Start()), len(r.Related[0]), len(r.Related[1])})
+	}
+	exp := [][]int{{10, 1, 1}, {30, 1, 1}}
+	if fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestRelateMixed(t *testing.T) {
+	pw := AsRelatable(iv("chr1", 12, 14).(SIPosition))
+	rels := Relate[*parsers.Interval, Relatable](CheckRelatedByOverlap, Less,
+		ivs(iv("chr1", 10, 20)), ivs(iv("chr1", 15, 35)), ivs(pw))
+	r, err := rels.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := RelatedAs[*parsers.Interval](r, 0)
+	if err != nil || len(a) != 1 || a[0].End() != 35 {
+		t.Errorf("unexpected related intervals from db 0: %v %v", a, err)
+	}
+	if _, err := RelatedAs[*parsers.Interval](r, 1); err == nil {
+		t.Error("expected an error for the wrong type")
+	}
+	b, err := RelatedAs[*PosWrap](r, 1)
+	if err != nil || len(b) != 1 {
+		t.Errorf("unexpected related intervals from db 1: %v %v", b, err)
+	}
+
+	// the query is not a *PosWrap.
+	bad := Relate[*PosWrap, Relatable](CheckRelatedByOverlap, Less, ivs(iv("chr1", 10, 20)), ivs(iv("chr1", 15, 35)))
+	_, err = bad.Next()
+	if e, ok := err.(*ErrType); !ok || e.Source != 0 || e.Got != "*parsers.Interval" {
+		t.Errorf("expected *ErrType, got %v", err)
+	}
+	if _, err2 := bad.Next(); err2 != err {
+		t.Errorf("expected error to be repeated, got %v", err2)
+	}
+}
@@ -0,0 +1,116 @@
+package irelate
+
+import (
+	"context"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// addSat returns a + b or the largest uint32 if that would overflow.
+func addSat(a, b uint32) uint32 {
+	if s := a + b; s >= a {
+		return s
+	}
+	return ^uint32(0)
+}
+
+// subSat returns a - b or 0 if that would underflow.
+func subSat(a, b uint32) uint32 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// WindowRelation relates query intervals to the database intervals that
+// overlap the query extended by Left bases upstream and Right bases downstream
+// (as with bedtools window -l -r). If StrandAware is true, Left and Right are
+// relative to the strand of the query (as with -sw) so they are swapped for
+// queries on the - strand.
+type WindowRelation struct {
+	Left, Right uint32
+	StrandAware bool
+	// Query is the source of the query intervals for Check. IRelate sets it
+	// from relativeTo. If neither or both intervals are from Query, the first
+	// argument to Check is the query.
+	Query uint32
+}
+
+// Window returns a WindowRelation. Use its IRelate or PIRelate methods, which
+// keep intervals in the sweep for as long as they may be in a window.
+func Window(left, right uint32, strandAware bool) *WindowRelation {
+	return &WindowRelation{Left: left, Right: right, StrandAware: strandAware}
+}
+
+// Reach returns the number of bases the window extends to the left and right
+// of a query in reference coordinates.
+func (w *WindowRelation) Reach() (left, right uint32) {
+	if !w.StrandAware || w.Left == w.Right {
+		return w.Left, w.Right
+	}
+	// either strand could be present.
+	m := w.Left
+	if w.Right > m {
+		m = w.Right
+	}
+	return m, m
+}
+
+// Check returns true if b overlaps the window around a (or a the window around b
+// if b is from Query).
+func (w *WindowRelation) Check(a, b Relatable) bool {
+	if b.Source() == w.Query && a.Source() != w.Query {
+		a, b = b, a
+	}
+	if !SameChrom(a.Chrom(), b.Chrom()) {
+		return false
+	}
+	left, right := w.Left, w.Right
+	if w.StrandAware && strand(a) == '-' {
+		left, right = right, left
+	}
+	return b.Start() < addSat(a.End(), right) && b.End() > subSat(a.Start(), left)
+}
+
+// IRelate is IRelate with w.Check as checkRelated. If relativeTo is >= 0, its
+// intervals are the queries whatever w.Query is.
+func (w *WindowRelation) IRelate(relativeTo int, less func(a, b Relatable) bool, streams ...RelatableIterator) RelatableIterator {
+	return w.IRelateContext(context.Background(), relativeTo, less, streams...)
+}
+
+// IRelateContext is the same as IRelate but stops when ctx is done (see IRelateContext).
+func (w *WindowRelation) IRelateContext(ctx context.Context, relativeTo int, less func(a, b Relatable) bool, streams ...RelatableIterator) RelatableIterator {
+	return w.irelate(ctx, ReportAll, relativeTo, less, streams...)
+}
+
+// irelate is IRelateContext with the query intervals that are sent selected by
+// mode (see IRelateMode).
+func (w *WindowRelation) irelate(ctx context.Context, mode Mode, relativeTo int, less func(a, b Relatable) bool, streams ...RelatableIterator) *irelate {
+	q := *w
+	if relativeTo >= 0 {
+		q.Query = uint32(relativeTo)
+	}
+	// a database interval must stay until it can not be in the window of a
+	// later query and a query until no later interval can be in its window.
+	left, right := w.Reach()
+	reach := left
+	if right > left {
+		reach = right
+	}
+	return newIRelate(ctx, mode, reach, relativeToMatrix(relativeTo, len(streams)), q.Check, less, streams...)
+}
+
+// PIRelate is PIRelateWithOptions with w.Check as CheckRelated. The region
+// queried from dbs for each chunk is extended by the window.
+func (w *WindowRelation) PIRelate(ctx context.Context, qstream RelatableIterator, opts *PIRelateOptions, dbs ...Queryable) (RelatableChannel, func() error) {
+	o := *opts
+	q := *w
+	q.Query = 0
+	o.CheckRelated = q.Check
+	o.sweep = func(ctx context.Context, streams ...RelatableIterator) RelatableIterator {
+		return q.irelate(ctx, o.Mode, 0, o.order(), streams...)
+	}
+	left, right := w.Reach()
+	o.padLeft, o.padRight = int(left), int(right)
+	return PIRelateWithOptions(ctx, qstream, &o, dbs...)
+}
@@ -0,0 +1,115 @@
+package irelate
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/brentp/irelate/interfaces"
+)
+
+// bruteWindow returns the sorted keys of the intervals in db within the window of q.
+func bruteWindow(q Relatable, left, right uint32, db []Relatable) []string {
+	var keys []string
+	for _, d := range db {
+		s := int(q.Start()) - int(left)
+		e := int(q.End()) + int(right)
+		if SameChrom(q.Chrom(), d.Chrom()) && int(d.Start()) < e && int(d.End()) > s {
+			keys = append(keys, key(d))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestWindowBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	for trial := 0; trial < 20; trial++ {
+		query := randomIntervals(rng, []string{"chr1", "chr2"}, 1+rng.Intn(50), 500)
+		db := randomIntervals(rng, []string{"chr1", "chr2"}, 1+rng.Intn(500), 200)
+		left, right := uint32(rng.Intn(5000)), uint32(rng.Intn(5000))
+		it := Window(left, right, false).IRelate(0, Less, ivs(copyIntervals(query)...), ivs(db...))
+		for {
+			r, err := it.Next()
+			if err != nil {
+				break
+			}
+			if exp, got := bruteWindow(r, left, right, db), relatedKeys(r); len(exp)+len(got) > 0 && !reflect.DeepEqual(exp, got) {
+				t.Fatalf("trial %d -l %d -r %d: %s expected %v, got %v", trial, left, right, key(r), exp, got)
+			}
+		}
+	}
+}
+
+func TestWindowRelativeTo(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	query := randomIntervals(rng, []string{"chr1"}, 40, 500)
+	db := randomIntervals(rng, []string{"chr1"}, 300, 200)
+	// the query is the second source and w.Query is left as 0.
+	it := Window(1000, 0, false).IRelate(1, Less, ivs(db...), ivs(copyIntervals(query)...))
+	n := 0
+	for {
+		r, err := it.Next()
+		if err != nil {
+			break
+		}
+		if exp, got := bruteWindow(r, 1000, 0, db), relatedKeys(r); len(exp)+len(got) > 0 && !reflect.DeepEqual(exp, got) {
+			t.Fatalf("%s expected %v, got %v", key(r), exp, got)
+		}
+		n++
+	}
+	if n != len(query) {
+		t.Errorf("expected %d intervals, got %d", len(query), n)
+	}
+}
+
+func TestWindowUnderflow(t *testing.T) {
+	w := Window(2000, 0, false)
+	a, b := iv("chr1", 100, 200), iv("chr1", 5000, 6000)
+	b.SetSource(1)
+	if w.Check(a, b) {
+		t.Errorf("expected chr1:5000-6000 not to be in the window of chr1:100-200")
+	}
+	if !w.Check(iv("chr1", 10, 20), iv("chr1", 0, 1)) {
+		t.Errorf("expected chr1:0-1 in the window of chr1:10-20")
+	}
+	if w.Check(iv("chr1", ^uint32(0)-10, ^uint32(0)), iv("chr1", 0, 1)) {
+		t.Errorf("expected overflow to be handled")
+	}
+}
+
+func TestWindowStrand(t *testing.T) {
+	w := Window(100, 0, true)
+	db := iv("chr1", 1050, 1150)
+	db.SetSource(1)
+	if w.Check(stranded("chr1", 900, 1000, "+"), db) {
+		t.Errorf("expected downstream interval not to be related on + strand")
+	}
+	if !w.Check(stranded("chr1", 900, 1000, "-"), db) {
+		t.Errorf("expected upstream interval to be related on - strand")
+	}
+	if l, r := w.Reach(); l != 100 || r != 100 {
+		t.Errorf("expected reach of 100 on both sides, got %d, %d", l, r)
+	}
+}
+
+func TestWindowPIRelate(t *testing.T) {
+	dbs := randomIntervals(rand.New(rand.NewSource(5)), []string{"chr1"}, 300, 50)
+	query := spaced("chr1", 500, 200, 10)
+	ch, errf := Window(300, 1000, false).PIRelate(context.Background(), ivs(query...), &PIRelateOptions{ChunkSize: 20, MaxGap: 100}, newSliceQueryable(dbs))
+	n := 0
+	for r := range ch {
+		if exp, got := bruteWindow(r, 300, 1000, dbs), relatedKeys(r); len(exp)+len(got) > 0 && !reflect.DeepEqual(exp, got) {
+			t.Errorf("%s: expected %v, got %v", key(r), exp, got)
+		}
+		n++
+	}
+	if err := errf(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(query) {
+		t.Errorf("expected %d intervals, got %d", len(query), n)
+	}
+}